@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessLogFormatCombined = "combined"
+	accessLogFormatJSON     = "json"
+
+	requestIDHeader = "X-Request-ID"
+
+	combinedLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+type accessLogContextKey struct{}
+
+// accessLogRecord collects what the handlers learn about a request (who
+// authenticated, which host was updated) for the access log line.
+type accessLogRecord struct {
+	RequestID string
+	User      string
+	Host      string
+}
+
+// accessLogWriter is where access log lines go, nil disables the access log
+var accessLogWriter io.WriteCloser
+
+// openAccessLog opens the access log file configured in c, if there is one
+func openAccessLog(c *ServerConfig) error {
+	if c.AccessLogFile == "" {
+		return nil
+	}
+	switch c.AccessLogFormat {
+	case accessLogFormatCombined, accessLogFormatJSON:
+	default:
+		return fmt.Errorf("unknown access log format %q", c.AccessLogFormat)
+	}
+	rf, err := newRotatingFile(c.AccessLogFile, c.LogMaxSize, c.LogMaxAge, c.LogMaxBackups)
+	if err != nil {
+		return err
+	}
+	accessLogWriter = rf
+	return nil
+}
+
+func closeAccessLog() {
+	if accessLogWriter != nil {
+		_ = accessLogWriter.Close()
+		accessLogWriter = nil
+	}
+}
+
+// accessLogRecordFromRequest returns the record attached by accessLogMiddleware, or a throwaway one
+func accessLogRecordFromRequest(r *http.Request) *accessLogRecord {
+	if rec, ok := r.Context().Value(accessLogContextKey{}).(*accessLogRecord); ok {
+		return rec
+	}
+	return &accessLogRecord{}
+}
+
+// statusRecorder remembers the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLogMiddleware tags every request with a request ID and writes one
+// access log line per request once the handler returns.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessLogRecord{RequestID: requestID(r)}
+		w.Header().Set(requestIDHeader, rec.RequestID)
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, rec)))
+
+		if accessLogWriter == nil {
+			return
+		}
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		var line []byte
+		if cfg.AccessLogFormat == accessLogFormatJSON {
+			line = jsonAccessLogLine(r, sr, rec, start)
+		} else {
+			line = combinedAccessLogLine(r, sr, rec, start)
+		}
+		if _, err := accessLogWriter.Write(line); err != nil {
+			getLogger().Warn("Can not write access log:", err)
+		}
+	})
+}
+
+// requestID reuses a sane incoming X-Request-ID or generates a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 64 && !strings.ContainsAny(id, " \t\r\n\"") {
+		return id
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// combinedAccessLogLine formats the Combined Log Format followed by the updated
+// host, the duration in milliseconds and the request ID.
+func combinedAccessLogLine(r *http.Request, sr *statusRecorder, rec *accessLogRecord, start time.Time) []byte {
+	size := "-"
+	if sr.bytes > 0 {
+		size = fmt.Sprintf("%d", sr.bytes)
+	}
+	return []byte(fmt.Sprintf("%s - %s [%s] %q %d %s %q %q %s %d %s\n",
+		getRealIP(r),
+		dashIfEmpty(rec.User),
+		start.Format(combinedLogTimeFormat),
+		r.Method+" "+redact(r.URL.RequestURI())+" "+r.Proto,
+		sr.status,
+		size,
+		dashIfEmpty(r.Referer()),
+		dashIfEmpty(r.UserAgent()),
+		dashIfEmpty(rec.Host),
+		time.Since(start).Milliseconds(),
+		rec.RequestID,
+	))
+}
+
+func jsonAccessLogLine(r *http.Request, sr *statusRecorder, rec *accessLogRecord, start time.Time) []byte {
+	line, _ := json.Marshal(map[string]interface{}{
+		"time":        start.UTC().Format(time.RFC3339Nano),
+		"client_ip":   getRealIP(r),
+		"user":        rec.User,
+		"method":      r.Method,
+		"uri":         redact(r.URL.RequestURI()),
+		"proto":       r.Proto,
+		"status":      sr.status,
+		"bytes":       sr.bytes,
+		"referer":     r.Referer(),
+		"user_agent":  r.UserAgent(),
+		"host":        rec.Host,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"request_id":  rec.RequestID,
+	})
+	return append(line, '\n')
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// serveLogged runs handler behind accessLogMiddleware and returns the answer and the access log line
+func serveLogged(t *testing.T, format string, handler http.HandlerFunc, r *http.Request) (*httptest.ResponseRecorder, string) {
+	var buf bytes.Buffer
+	savedCfg, savedWriter := cfg, accessLogWriter
+	cfg, accessLogWriter = &ServerConfig{AccessLogFormat: format}, nopWriteCloser{&buf}
+	defer func() { cfg, accessLogWriter = savedCfg, savedWriter }()
+
+	w := httptest.NewRecorder()
+	accessLogMiddleware(handler).ServeHTTP(w, r)
+	return w, buf.String()
+}
+
+func updateHandler(w http.ResponseWriter, r *http.Request) {
+	rec := accessLogRecordFromRequest(r)
+	rec.User, rec.Host = "user1", "home.example.com"
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte("good 203.0.113.7"))
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/fetch-it?ip=203.0.113.7&password=hunter22", nil)
+	r.RemoteAddr = "198.51.100.9:40000"
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set(requestIDHeader, "req-42")
+	w, line := serveLogged(t, accessLogFormatCombined, updateHandler, r)
+
+	expected := regexp.MustCompile(`^198\.51\.100\.9 - user1 \[[^\]]+\] "GET /fetch-it\?ip=203\.0\.113\.7&password=\*\*\* HTTP/1\.1" 201 16 "-" "curl/8\.0" home\.example\.com \d+ req-42\n$`)
+	if !expected.MatchString(line) {
+		t.Errorf("unexpected combined line %q", line)
+	}
+	if w.Header().Get(requestIDHeader) != "req-42" {
+		t.Errorf("expected the incoming request ID but got %q", w.Header().Get(requestIDHeader))
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	r.Header.Set(requestIDHeader, "bad id with spaces")
+	// a handler writing nothing answers 200
+	w, line := serveLogged(t, accessLogFormatJSON, func(http.ResponseWriter, *http.Request) {}, r)
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", line, err)
+	}
+	id := w.Header().Get(requestIDHeader)
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) || fields["request_id"] != id {
+		t.Errorf("expected a generated request ID but got %q and %v", id, fields["request_id"])
+	}
+	if fields["status"] != float64(http.StatusOK) || fields["bytes"] != float64(0) || fields["uri"] != "/healthz" || fields["user"] != "" {
+		t.Errorf("unexpected JSON line %s", line)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: w}
+	sr.WriteHeader(http.StatusBadGateway)
+	sr.WriteHeader(http.StatusOK)
+	_, _ = sr.Write([]byte("fail"))
+	_, _ = sr.Write([]byte("ed"))
+	if sr.status != http.StatusBadGateway || sr.bytes != 6 {
+		t.Errorf("expected status 502 and 6 bytes but got %d and %d", sr.status, sr.bytes)
+	}
+	if http.NewResponseController(sr).Flush() != nil {
+		t.Errorf("the recorder must unwrap to the real writer")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+	// The example of the AWS Signature Version 4 documentation
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if actual := req.Header.Get("Authorization"); actual != expected {
+		t.Errorf("signature expected: '%s' but actual: '%s'", expected, actual)
+	}
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Errorf("unexpected X-Amz-Date %s", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+func TestAWSURIEscape(t *testing.T) {
+	testCases := map[string]string{
+		"example.com":  "example.com",
+		"a b+c":        "a%20b%2Bc",
+		"~_-.":         "~_-.",
+		"/hostedzone/": "%2Fhostedzone%2F",
+	}
+	for value, expected := range testCases {
+		if actual := awsURIEscape(value); actual != expected {
+			t.Errorf("awsURIEscape(%q) expected %s but got %s", value, expected, actual)
+		}
+	}
+}
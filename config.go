@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/go-ini/ini"
 	"github.com/sirupsen/logrus"
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 )
 
 type ServerConfig struct {
@@ -19,6 +22,48 @@ type ServerConfig struct {
 	SSL          bool
 	Debug        bool
 	redirectHttp int
+
+	// Readiness checks
+	CertMinValidity   time.Duration
+	ReadyProbeURL     string
+	ReadyProbeTimeout time.Duration
+
+	// Logging
+	LogFormat     string
+	LogOutput     string
+	LogFile       string
+	LogMaxSize    int64
+	LogMaxAge     time.Duration
+	LogMaxBackups int
+	SyslogAddress string
+	SyslogTag     string
+
+	// Access log
+	AccessLogFile   string
+	AccessLogFormat string
+
+	// HTTP server limits
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+
+	// Outbound provider calls
+	Upstream UpstreamConfig
+
+	// Resolver for the no-change lookup, the system resolver when empty
+	Resolvers       []string
+	ResolverTimeout time.Duration
+
+	// Relay chaining
+	InstanceID   string
+	RelayMaxHops int
+
+	// State kept across restarts, in memory only when StateFile is empty
+	StateFile          string
+	StateFlushInterval time.Duration
 }
 
 var cfg *ServerConfig
@@ -41,6 +86,33 @@ func getConfig(path string) *ServerConfig {
 			SSL:          false,
 			Debug:        true,
 			redirectHttp: 0,
+
+			CertMinValidity:   14 * 24 * time.Hour,
+			ReadyProbeTimeout: 3 * time.Second,
+
+			LogFormat:     logFormatText,
+			LogOutput:     logOutputStderr,
+			LogMaxSize:    100 * 1024 * 1024,
+			LogMaxBackups: 7,
+			SyslogTag:     "ddns-proxy",
+
+			AccessLogFormat: accessLogFormatCombined,
+
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    64 * 1024,
+			ShutdownTimeout:   30 * time.Second,
+
+			Upstream: defaultUpstreamConfig,
+
+			ResolverTimeout: 2 * time.Second,
+
+			InstanceID:   newInstanceID(),
+			RelayMaxHops: 5,
+
+			StateFlushInterval: 30 * time.Second,
 		}
 	if fileIsReadable(&path) {
 		configFileName = path
@@ -81,10 +153,149 @@ func getConfig(path string) *ServerConfig {
 	if httpRedirectPort, err := settings.Section(sectionName).Key("http-port").Int(); (err == nil) && (httpRedirectPort > 0) {
 		defaultConfig.redirectHttp = httpRedirectPort
 	}
+	if days, err := settings.Section(sectionName).Key("cert-min-validity-days").Int(); err == nil && days >= 0 {
+		defaultConfig.CertMinValidity = time.Duration(days) * 24 * time.Hour
+	}
+	defaultConfig.ReadyProbeURL = settings.Section(sectionName).Key("ready-probe-url").String()
+	if timeout, err := settings.Section(sectionName).Key("ready-probe-timeout").Duration(); err == nil && timeout > 0 {
+		defaultConfig.ReadyProbeTimeout = timeout
+	}
+
+	if logFormat := settings.Section(sectionName).Key("log-format").String(); logFormat != "" {
+		defaultConfig.LogFormat = logFormat
+	}
+	if logOutput := settings.Section(sectionName).Key("log-output").String(); logOutput != "" {
+		defaultConfig.LogOutput = logOutput
+	}
+	defaultConfig.LogFile = settings.Section(sectionName).Key("log-file").String()
+	if maxSize, err := settings.Section(sectionName).Key("log-max-size-mb").Int64(); err == nil && maxSize >= 0 {
+		defaultConfig.LogMaxSize = maxSize * 1024 * 1024
+	}
+	if maxAge, err := settings.Section(sectionName).Key("log-max-age").Duration(); err == nil && maxAge >= 0 {
+		defaultConfig.LogMaxAge = maxAge
+	}
+	if maxBackups, err := settings.Section(sectionName).Key("log-max-backups").Int(); err == nil && maxBackups >= 0 {
+		defaultConfig.LogMaxBackups = maxBackups
+	}
+	defaultConfig.SyslogAddress = settings.Section(sectionName).Key("syslog-address").String()
+	if syslogTag := settings.Section(sectionName).Key("syslog-tag").String(); syslogTag != "" {
+		defaultConfig.SyslogTag = syslogTag
+	}
+	defaultConfig.AccessLogFile = settings.Section(sectionName).Key("access-log").String()
+	if accessLogFormat := settings.Section(sectionName).Key("access-log-format").String(); accessLogFormat != "" {
+		defaultConfig.AccessLogFormat = accessLogFormat
+	}
+	for key, target := range map[string]*time.Duration{
+		"read-timeout":        &defaultConfig.ReadTimeout,
+		"read-header-timeout": &defaultConfig.ReadHeaderTimeout,
+		"write-timeout":       &defaultConfig.WriteTimeout,
+		"idle-timeout":        &defaultConfig.IdleTimeout,
+		"shutdown-timeout":    &defaultConfig.ShutdownTimeout,
+	} {
+		if timeout, err := settings.Section(sectionName).Key(key).Duration(); err == nil && timeout > 0 {
+			*target = timeout
+		}
+	}
+	if maxHeaderBytes, err := settings.Section(sectionName).Key("max-header-bytes").Int(); err == nil && maxHeaderBytes > 0 {
+		defaultConfig.MaxHeaderBytes = maxHeaderBytes
+	}
+	if err := readUpstreamConfig(settings.Section(sectionName), &defaultConfig.Upstream); err != nil {
+		getLogger().WithError(err).Fatal("Invalid upstream settings")
+	}
+	defaultConfig.Resolvers = settings.Section(sectionName).Key("resolver").Strings(",")
+	if timeout, err := settings.Section(sectionName).Key("resolver-timeout").Duration(); err == nil && timeout > 0 {
+		defaultConfig.ResolverTimeout = timeout
+	}
+	if instanceID := settings.Section(sectionName).Key("instance-id").String(); instanceID != "" {
+		defaultConfig.InstanceID = instanceID
+	}
+	if maxHops, err := settings.Section(sectionName).Key("relay-max-hops").Int(); err == nil && maxHops >= 0 {
+		defaultConfig.RelayMaxHops = maxHops
+	}
+	defaultConfig.StateFile = settings.Section(sectionName).Key("state-file").String()
+	if interval, err := settings.Section(sectionName).Key("state-flush-interval").Duration(); err == nil && interval > 0 {
+		defaultConfig.StateFlushInterval = interval
+	}
 
 	return &defaultConfig
 }
 
+// newInstanceID returns a random ID identifying this process in relay chains
+func newInstanceID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readUpstreamConfig(section *ini.Section, uc *UpstreamConfig) error {
+	for key, target := range map[string]*time.Duration{
+		"upstream-timeout":                 &uc.Timeout,
+		"upstream-dial-timeout":            &uc.DialTimeout,
+		"upstream-keep-alive":              &uc.KeepAlive,
+		"upstream-tls-handshake-timeout":   &uc.TLSHandshakeTimeout,
+		"upstream-response-header-timeout": &uc.ResponseHeaderTimeout,
+		"upstream-idle-conn-timeout":       &uc.IdleConnTimeout,
+	} {
+		if section.Key(key).String() == "" {
+			continue
+		}
+		timeout, err := section.Key(key).Duration()
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid %s %q", key, section.Key(key).String())
+		}
+		*target = timeout
+	}
+	for key, target := range map[string]*int{
+		"upstream-max-idle-conns":          &uc.MaxIdleConns,
+		"upstream-max-idle-conns-per-host": &uc.MaxIdleConnsPerHost,
+		"upstream-max-conns-per-host":      &uc.MaxConnsPerHost,
+	} {
+		if section.Key(key).String() == "" {
+			continue
+		}
+		n, err := section.Key(key).Int()
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", key, section.Key(key).String())
+		}
+		*target = n
+	}
+	if caFile := section.Key("upstream-ca-file").String(); caFile != "" {
+		uc.CAFile = caFile
+	}
+	if value := section.Key("upstream-insecure-skip-verify").String(); value != "" {
+		insecure, err := section.Key("upstream-insecure-skip-verify").Bool()
+		if err != nil {
+			return fmt.Errorf("invalid upstream-insecure-skip-verify %q", value)
+		}
+		uc.InsecureSkipVerify = insecure
+	}
+	if minVersion := section.Key("upstream-tls-min-version").String(); minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version %q", minVersion)
+		}
+		uc.TLSMinVersion = version
+	}
+	if proxyURL := section.Key("upstream-proxy").String(); proxyURL != "" {
+		proxy, err := parseProxySettings(proxyURL, section.Key("upstream-no-proxy").String())
+		if err != nil {
+			return err
+		}
+		uc.Proxy = proxy
+		registerSecrets(proxyPassword(proxy))
+	}
+	var allowPrivate *bool
+	if allow, err := section.Key("outbound-allow-private").Bool(); err == nil {
+		allowPrivate = &allow
+	}
+	policy, err := parseOutboundPolicy(uc.Policy, section.Key("outbound-allowed-schemes").String(), section.Key("outbound-allowed-hosts").String(), allowPrivate)
+	if err != nil {
+		return err
+	}
+	uc.Policy = *policy
+	return nil
+}
+
 func getConfigFilePath() (string, error) {
 	var (
 		exeName         string
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types, classes and header bits used by the resolver and the RFC 2136 client
+const (
+	dnsTypeA     uint16 = 1
+	dnsTypeNS    uint16 = 2
+	dnsTypeCNAME uint16 = 5
+	dnsTypeSOA   uint16 = 6
+	dnsTypeTXT   uint16 = 16
+	dnsTypeAAAA  uint16 = 28
+	dnsTypeOPT   uint16 = 41
+	dnsTypeTSIG  uint16 = 250
+	dnsTypeANY   uint16 = 255
+
+	dnsClassINET uint16 = 1
+	dnsClassNONE uint16 = 254
+	dnsClassANY  uint16 = 255
+
+	dnsFlagResponse  uint16 = 1 << 15
+	dnsFlagTruncated uint16 = 1 << 9
+	dnsFlagRecursion uint16 = 1 << 8
+
+	dnsOpcodeQuery  uint16 = 0
+	dnsOpcodeUpdate uint16 = 5
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+	dnsRcodeYXDomain = 6
+	dnsRcodeYXRRSet  = 7
+	dnsRcodeNXRRSet  = 8
+	dnsRcodeNotAuth  = 9
+	dnsRcodeNotZone  = 10
+
+	dnsHeaderLen = 12
+	// dnsMaxUDPSize is the EDNS0 buffer size advertised in queries
+	dnsMaxUDPSize = 1232
+)
+
+var dnsRcodeNames = map[int]string{
+	dnsRcodeSuccess:  "NOERROR",
+	dnsRcodeFormErr:  "FORMERR",
+	dnsRcodeServFail: "SERVFAIL",
+	dnsRcodeNXDomain: "NXDOMAIN",
+	dnsRcodeNotImp:   "NOTIMP",
+	dnsRcodeRefused:  "REFUSED",
+	dnsRcodeYXDomain: "YXDOMAIN",
+	dnsRcodeYXRRSet:  "YXRRSET",
+	dnsRcodeNXRRSet:  "NXRRSET",
+	dnsRcodeNotAuth:  "NOTAUTH",
+	dnsRcodeNotZone:  "NOTZONE",
+}
+
+func dnsRcodeName(rcode int) string {
+	if name, ok := dnsRcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// dnsQuestion is an entry of the question section, the zone section of an UPDATE
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// dnsRR is a resource record with its RDATA kept in wire format
+type dnsRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// dnsMessage is a DNS message (RFC 1035 section 4). For UPDATE messages
+// (RFC 2136) the sections are zone, prerequisite, update and additional.
+type dnsMessage struct {
+	ID         uint16
+	Flags      uint16
+	Questions  []dnsQuestion
+	Answers    []dnsRR
+	Authority  []dnsRR
+	Additional []dnsRR
+}
+
+func newDNSID() uint16 {
+	b := make([]byte, 2)
+	_, _ = rand.Read(b)
+	return binary.BigEndian.Uint16(b)
+}
+
+// newDNSQuery builds a recursive query for name with an EDNS0 OPT record
+func newDNSQuery(name string, qtype uint16) *dnsMessage {
+	return &dnsMessage{
+		ID:        newDNSID(),
+		Flags:     dnsFlagRecursion,
+		Questions: []dnsQuestion{{Name: name, Type: qtype, Class: dnsClassINET}},
+		Additional: []dnsRR{
+			// The class of an OPT record is the UDP payload size
+			{Name: ".", Type: dnsTypeOPT, Class: dnsMaxUDPSize},
+		},
+	}
+}
+
+func (m *dnsMessage) Rcode() int {
+	return int(m.Flags & 0x000f)
+}
+
+func (m *dnsMessage) Opcode() uint16 {
+	return (m.Flags >> 11) & 0x000f
+}
+
+func (m *dnsMessage) pack() ([]byte, error) {
+	b := make([]byte, dnsHeaderLen, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendDNSName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, section := range [][]dnsRR{m.Answers, m.Authority, m.Additional} {
+		for _, rr := range section {
+			if b, err = appendDNSRR(b, rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendDNSRR(b []byte, rr dnsRR) ([]byte, error) {
+	b, err := appendDNSName(b, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rr.Data) > 0xffff {
+		return nil, fmt.Errorf("rdata of %s too long", rr.Name)
+	}
+	b = binary.BigEndian.AppendUint16(b, rr.Type)
+	b = binary.BigEndian.AppendUint16(b, rr.Class)
+	b = binary.BigEndian.AppendUint32(b, rr.TTL)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+// appendDNSName appends name in uncompressed wire format
+func appendDNSName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("dns name %q too long", name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid dns name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// canonicalDNSName returns name lower-cased and fully qualified, as used in TSIG digests
+func canonicalDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+func unpackDNSMessage(b []byte) (*dnsMessage, error) {
+	if len(b) < dnsHeaderLen {
+		return nil, fmt.Errorf("dns message too short")
+	}
+	m := &dnsMessage{
+		ID:    binary.BigEndian.Uint16(b[0:]),
+		Flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	counts := []int{
+		int(binary.BigEndian.Uint16(b[4:])),
+		int(binary.BigEndian.Uint16(b[6:])),
+		int(binary.BigEndian.Uint16(b[8:])),
+		int(binary.BigEndian.Uint16(b[10:])),
+	}
+
+	off := dnsHeaderLen
+	for i := 0; i < counts[0]; i++ {
+		name, next, err := readDNSName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, fmt.Errorf("dns question truncated")
+		}
+		m.Questions = append(m.Questions, dnsQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next:]),
+			Class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+
+	sections := []*[]dnsRR{&m.Answers, &m.Authority, &m.Additional}
+	for s, section := range sections {
+		for i := 0; i < counts[s+1]; i++ {
+			name, next, err := readDNSName(b, off)
+			if err != nil {
+				return nil, err
+			}
+			if next+10 > len(b) {
+				return nil, fmt.Errorf("dns record truncated")
+			}
+			rr := dnsRR{
+				Name:  name,
+				Type:  binary.BigEndian.Uint16(b[next:]),
+				Class: binary.BigEndian.Uint16(b[next+2:]),
+				TTL:   binary.BigEndian.Uint32(b[next+4:]),
+			}
+			length := int(binary.BigEndian.Uint16(b[next+8:]))
+			off = next + 10
+			if off+length > len(b) {
+				return nil, fmt.Errorf("dns record data truncated")
+			}
+			rr.Data = b[off : off+length]
+			off += length
+			*section = append(*section, rr)
+		}
+	}
+	return m, nil
+}
+
+// readDNSName reads a possibly compressed name at off and returns it with the offset after it
+func readDNSName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, fmt.Errorf("dns name truncated")
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, fmt.Errorf("dns name pointer truncated")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("dns name pointer loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case length > 63:
+			return "", 0, fmt.Errorf("invalid dns label length")
+		default:
+			if off+1+length > len(b) {
+				return "", 0, fmt.Errorf("dns label truncated")
+			}
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// addressesOf returns the A and AAAA records of rrs
+func addressesOf(rrs []dnsRR) []net.IP {
+	var ips []net.IP
+	for _, rr := range rrs {
+		if (rr.Type == dnsTypeA && len(rr.Data) == net.IPv4len) || (rr.Type == dnsTypeAAAA && len(rr.Data) == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte{}, rr.Data...)))
+		}
+	}
+	return ips
+}
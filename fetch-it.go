@@ -3,12 +3,11 @@ package main
 import (
 	_ "embed"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -29,6 +28,17 @@ type UserInfo struct {
 	DDPass      string `json:"dd-pass,omitempty,default:''"`
 	UrlPattern  string `json:"url,omitempty,default:''"`
 	ForceUpdate bool   `json:"force-update,omitempty,default:false"`
+	// AcceptRelayForce honors the force flag of updates relayed by another instance
+	AcceptRelayForce bool `json:"accept-relay-force,omitempty,default:false"`
+
+	ProviderName string `json:"provider,omitempty,default:'url'"`
+
+	// Proxy overrides the global upstream proxy for this entry
+	Proxy *ProxySettings `json:"-"`
+	// Outbound overrides the global outbound policy for this entry
+	Outbound *OutboundPolicy `json:"-"`
+	// provider applies the updates of this entry, built from ProviderName
+	provider Provider
 }
 
 const (
@@ -55,6 +65,24 @@ func main() {
 	if cfg.Debug {
 		getLogger().SetLevel(logrus.DebugLevel)
 	}
+	if err := configureLogger(cfg); err != nil {
+		getLogger().WithError(err).Fatal("Failed to setup logging")
+	}
+	defer closeLogger()
+	if err := openAccessLog(cfg); err != nil {
+		getLogger().WithError(err).Fatal("Failed to open access log")
+	}
+	defer closeAccessLog()
+	if err := setupUpstreamClient(cfg); err != nil {
+		getLogger().WithError(err).Fatal("Failed to setup upstream client")
+	}
+	if err := setupHostResolver(cfg); err != nil {
+		getLogger().WithError(err).Fatal("Failed to setup resolver")
+	}
+	if err := openStateStore(cfg); err != nil {
+		getLogger().WithError(err).Fatal("Failed to open state store")
+	}
+	defer closeStateStore()
 	for i := 1; i < len(os.Args); i++ {
 		if strings.TrimSpace(strings.ToLower(os.Args[i])) == "-cc" {
 			printCopyright(true)
@@ -68,30 +96,19 @@ func main() {
 
 	http.HandleFunc("/", fetchItHandlerFunc)
 	http.HandleFunc("/about", AboutHandlerFunc)
+	http.HandleFunc("/healthz", healthzHandlerFunc)
+	http.HandleFunc("/readyz", readyzHandlerFunc)
 
-	// Start the HTTP server
-	port := cfg.HostName + ":" + strconv.Itoa(cfg.Port)
-	getLogger().Infof("Starting server on port %s...\n", port)
-	var err error
-	if cfg.SSL {
-		if cfg.CAPath != "" {
-			cfg.CAFile = path.Join(cfg.CAPath, cfg.CAFile)
-			cfg.CertFile = path.Join(cfg.CAPath, cfg.CertFile)
-			cfg.KeyFile = path.Join(cfg.CAPath, cfg.KeyFile)
-		}
-		if cfg.redirectHttp > 0 {
-			go func() {
-				err := http.ListenAndServe(cfg.HostName+":"+strconv.Itoa(cfg.redirectHttp), http.HandlerFunc(redirectHandler))
-				if err != nil {
-					getLogger().Errorf("Can not redirect http to https on port %d: %v", cfg.redirectHttp, err)
-				}
-			}()
-		}
-		err = http.ListenAndServeTLS(port, cfg.CertFile, cfg.KeyFile, nil)
-	} else {
-		err = http.ListenAndServe(port, nil)
+	handler := accessLogMiddleware(http.DefaultServeMux)
+
+	if cfg.SSL && cfg.CAPath != "" {
+		cfg.CAFile = path.Join(cfg.CAPath, cfg.CAFile)
+		cfg.CertFile = path.Join(cfg.CAPath, cfg.CertFile)
+		cfg.KeyFile = path.Join(cfg.CAPath, cfg.KeyFile)
 	}
-	if err != nil {
+
+	// Start the HTTP server, it returns after a graceful shutdown
+	if err := serve(handler); err != nil {
 		getLogger().Error("Error starting server:", err)
 	}
 }
@@ -157,6 +174,7 @@ func readCredentialsFromFile(filename string) error {
 	json, _ = sjson.Delete(json, "#")
 
 	// Parse JSON
+	var parseErr error
 	gjson.Parse(json).ForEach(func(key, value gjson.Result) bool {
 		username := key.String()
 
@@ -175,26 +193,52 @@ func readCredentialsFromFile(filename string) error {
 				creds.DDUser = val.String()
 			} else if field == "dd-pass" {
 				creds.DDPass = val.String()
+			} else if field == "provider" {
+				creds.ProviderName = val.String()
 			} else if field == "force-update" {
 				forceUpdate, _ := strconv.ParseBool(val.String())
 				creds.ForceUpdate = forceUpdate
+			} else if field == "accept-relay-force" {
+				acceptRelayForce, _ := strconv.ParseBool(val.String())
+				creds.AcceptRelayForce = acceptRelayForce
 			}
 			return true
 		})
 
+		proxy, policy, err := entryOutbound(value)
+		if err != nil {
+			parseErr = fmt.Errorf("user %s: %v", username, err)
+			return false
+		}
+		creds.Proxy, creds.Outbound = proxy, policy
+
+		provider, err := newProvider(username, value)
+		if err != nil {
+			parseErr = fmt.Errorf("user %s: %v", username, err)
+			return false
+		}
+		creds.provider = provider
+
 		validCredentials[username] = creds
+		registerSecrets(creds.Password, creds.DDPass)
 
 		return true // Continue iterating through JSONC
 	})
 
-	return nil
+	return parseErr
 }
 
 func checkValidAPICredentials(user *UserInfo) bool {
+	if user.provider == nil {
+		return false
+	}
+	// Other providers validate their settings when the credential file is loaded
+	if user.ProviderName != "" && user.ProviderName != providerURL {
+		return true
+	}
 	userExists := strings.TrimSpace(user.DDUser) != ""
 	passExists := strings.TrimSpace(user.DDPass) != ""
-	getLogger().Debug("User exists:", userExists, []string{user.DDUser})
-	getLogger().Debug("Pass exists:", passExists, []string{user.DDPass})
+	getLogger().Debug("User exists:", userExists, " Pass exists:", passExists)
 
 	return userExists && passExists
 }
@@ -235,7 +279,7 @@ func authorize(w http.ResponseWriter, r *http.Request) (creds *UserInfo, ok bool
 	username := parts[0]
 	password := parts[1]
 
-	getLogger().Debug("Header: username:", username, " password:", password)
+	getLogger().Debug("Header: username:", username)
 
 	// Check if the provided credentials are valid
 	var exists bool
@@ -247,6 +291,7 @@ func authorize(w http.ResponseWriter, r *http.Request) (creds *UserInfo, ok bool
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return nil, false
 	}
+	accessLogRecordFromRequest(r).User = username
 	return creds, true
 }
 
@@ -262,6 +307,101 @@ func getRealIP(r *http.Request) string {
 	return ip
 }
 
+// requestedIPAddresses reads the addresses to set from the ip parameter. A
+// relay forwards all addresses of an update in it as a comma separated list.
+func requestedIPAddresses(r *http.Request) ([]net.IP, error) {
+	var ips []net.IP
+	for _, value := range strings.Split(r.URL.Query().Get("ip"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// entryOutbound reads the proxy and outbound policy keys of a credential
+// entry, or of a provider of the multi provider; nil values keep the ones in
+// effect
+func entryOutbound(entry gjson.Result) (*ProxySettings, *OutboundPolicy, error) {
+	var proxy *ProxySettings
+	if proxyURL := entry.Get("proxy").String(); proxyURL != "" {
+		var err error
+		if proxy, err = parseProxySettings(proxyURL, entry.Get("no-proxy").String()); err != nil {
+			return nil, nil, err
+		}
+		registerSecrets(proxyPassword(proxy))
+	}
+
+	if !entry.Get("allowed-schemes").Exists() && !entry.Get("allowed-hosts").Exists() && !entry.Get("allow-private").Exists() {
+		return proxy, nil, nil
+	}
+	var allowPrivate *bool
+	if entry.Get("allow-private").Exists() {
+		allow := entry.Get("allow-private").Bool()
+		allowPrivate = &allow
+	}
+	policy, err := parseOutboundPolicy(cfg.Upstream.Policy, entry.Get("allowed-schemes").String(), entry.Get("allowed-hosts").String(), allowPrivate)
+	if err != nil {
+		return nil, nil, err
+	}
+	return proxy, policy, nil
+}
+
+// requestedForce reports whether a relayed request carries the force flag of
+// the update it forwards. Clients can set the relay headers themselves, so the
+// flag only counts for entries that accept it from the relaying instance.
+func requestedForce(r *http.Request, creds *UserInfo, via []string) bool {
+	return creds.AcceptRelayForce && len(via) > 0 && r.URL.Query().Get("force") == "yes"
+}
+
+// requestedRecordChange reports whether the update sets more than the
+// addresses (a TXT record or clearing the records) that provider can handle,
+// which the check of the current addresses must not answer. params are the
+// merged query and form parameters the provider reads too.
+func requestedRecordChange(params map[string]interface{}, provider Provider) bool {
+	if _, ok := provider.(txtProvider); !ok {
+		return false
+	}
+	_, txt := params["txt"].(string)
+	clearRecords, _ := params["clear"].(string)
+	return txt || isTruthy(clearRecords)
+}
+
+// isTruthy reports whether a request parameter is set to yes, true or 1
+func isTruthy(value string) bool {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// ipsAlreadySet reports whether host already resolves to every address of ips
+func ipsAlreadySet(r *http.Request, host string, ips []net.IP) bool {
+	current, err := lookupHostIPs(r.Context(), host)
+	if err != nil || len(current) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		found := false
+		for _, c := range current {
+			if c.Equal(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func fetchItHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	creds, valid := authorize(w, r)
 	if !valid {
@@ -273,89 +413,95 @@ func fetchItHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestedIpAddress := r.URL.Query().Get("ip")
-	if requestedIpAddress == "" {
-		requestedIpAddress = getRealIP(r)
+	accessLogRecordFromRequest(r).Host = creds.Host
+
+	hops, via, err := relayHopsFromRequest(r)
+	if err != nil {
+		getLogger().Warn("Rejected relayed request: ", err)
+		w.Header().Set(resultHeader, string(ResultServerError))
+		http.Error(w, err.Error(), http.StatusLoopDetected)
+		return
+	}
+	if hostname := r.URL.Query().Get("hostname"); len(via) > 0 && hostname != "" && !strings.EqualFold(hostname, creds.Host) {
+		getLogger().Warnf("Relayed update for %s does not match host %s", hostname, creds.Host)
+		writeUpdateResult(w, &UpdateResult{Code: ResultNoHost, Body: "relayed host does not match " + creds.Host})
+		return
 	}
 
-	theUrl := ""
-	getLogger().Debug("requestedIpAddress:", requestedIpAddress)
-	pattern := defaultURLPattern
-	if checkValidAPICredentials(creds) && requestedIpAddress != "" {
-		if creds.UrlPattern != "" {
-			pattern = creds.UrlPattern
+	requestedIPs, err := requestedIPAddresses(r)
+	if err != nil {
+		getLogger().Warn("Bad update request: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requestedIPs) == 0 {
+		if ip := net.ParseIP(getRealIP(r)); ip != nil {
+			requestedIPs = append(requestedIPs, ip)
 		}
+	}
 
-		theUrl = Interpolate(pattern, map[string]interface{}{
-			"ddhost": url.QueryEscape(creds.Host),
-			"dduser": url.QueryEscape(creds.DDUser),
-			"ddpass": url.QueryEscape(creds.DDPass),
-			"ddip":   url.QueryEscape(requestedIpAddress),
-		})
-
-		if !creds.ForceUpdate {
-			ips, err := net.LookupIP(creds.Host)
-			if err == nil && len(ips) > 0 {
-				if ips[0].String() == requestedIpAddress {
-					getLogger().Infof("IP %s already is set for %s", requestedIpAddress, creds.Host)
-					w.Header().Set("Content-Type", "text/plain")
-					_, _ = w.Write([]byte("nochn : IP already is set "))
-					_, _ = w.Write([]byte(requestedIpAddress))
-					return
-				}
-			}
-		}
+	getLogger().Debug("requestedIpAddress:", requestedIPs)
+	if !checkValidAPICredentials(creds) || len(requestedIPs) == 0 {
+		getLogger().Warn("Credentials are not valid for host: ", creds.Host)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		getLogger().Debugf("%v", []string{"URL", theUrl})
+	paramsMap, err := GetParamsAsMap(r)
+	if err != nil {
+		getLogger().Warn("Bad update request: ", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
 
-		if cfg.Debug {
-			time.Sleep(10 * time.Second)
-		}
-		// Create an HTTP client with a timeout of 5 seconds
-		client := &http.Client{
-			Timeout: 5 * time.Second,
-		}
+	update := &UpdateRequest{
+		Host:   creds.Host,
+		IPs:    requestedIPs,
+		Force:  creds.ForceUpdate || requestedForce(r, creds, via) || requestedRecordChange(*paramsMap, creds.provider),
+		Params: *paramsMap,
+		Creds:  creds,
+		Hops:   hops,
+		Via:    via,
+	}
 
-		paramsMap, err := GetParamsAsMap(r)
-		theUrl = Interpolate(theUrl, *paramsMap)
-		// Send an HTTP GET request using the client
-		getLogger().Debugf("Compiled URL using parameters: %v", []string{"URL", theUrl})
-		resp, err := client.Get(theUrl)
-		if err != nil {
-			getLogger().Warn("Error making GET request:", err)
-			http.Error(w, "Error making GET request", http.StatusBadRequest)
-			return
-		}
-		defer func(Body io.ReadCloser) {
-			_ = Body.Close()
-		}(resp.Body)
+	if !update.Force && ipsAlreadySet(r, update.Host, update.IPs) {
+		ipList := strings.Join(update.IPStrings(), ",")
+		getLogger().Infof("IP %s already is set for %s", ipList, update.Host)
+		w.Header().Set(resultHeader, string(ResultNoChange))
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("nochn : IP already is set "))
+		_, _ = w.Write([]byte(ipList))
+		return
+	}
 
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			getLogger().Warn("Error reading response body", err)
-			http.Error(w, "Error reading response body", http.StatusBadGateway)
+	if cfg.Debug {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+			getLogger().Debug("client went away: ", r.Context().Err())
 			return
 		}
-
-		getLogger().Debug("Body: ", string(body))
-		getLogger().Debug("Header: ", resp.Header)
-		// Check if the response body contains "ok"
-
-		if resp.StatusCode == http.StatusOK {
-			getLogger().Infof("Respons: %d\n%s", resp.StatusCode, body)
-			_, _ = fmt.Fprintf(w, "OK %d\n%s", resp.StatusCode, body)
-		} else {
-			getLogger().Warnf("Respons: %d\n%s", resp.StatusCode, body)
-			_, _ = fmt.Fprintf(w, "Fail: %d\n%s", resp.StatusCode, body)
-		}
-
-	} else {
-		getLogger().Warn("Credentials are not valid, ", creds)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	}
 
-	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	// Provider calls are canceled with the incoming request
+	result, err := creds.provider.Update(outboundContext(r.Context(), creds), update)
+	if errors.Is(err, errBadUpdateRequest) {
+		getLogger().Warn("Bad update request: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	providerName := creds.ProviderName
+	if providerName == "" {
+		providerName = providerURL
+	}
+	recordUpdateHistory(update.Host, historyEntries(accessLogRecordFromRequest(r).User, providerName, update, result, err)...)
+	if err != nil {
+		getLogger().Warn("Error calling provider: ", err)
+		w.Header().Set(resultHeader, string(ResultServerError))
+		http.Error(w, "Error making update request", http.StatusBadGateway)
+		return
+	}
+	writeUpdateResult(w, result)
 }
 
 //go:embed copyright-banner.txt
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	checkStatusOK      = "ok"
+	checkStatusFail    = "fail"
+	checkStatusSkipped = "skipped"
+)
+
+var startTime = time.Now()
+
+// readinessCheck is a single named dependency check reported by /readyz.
+// A check returns a short human-readable message on success, or an error
+// when the dependency is not usable.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (string, error)
+}
+
+// errCheckSkipped marks a check that does not apply to the current configuration
+var errCheckSkipped = fmt.Errorf("skipped")
+
+var readinessChecks = []readinessCheck{
+	{name: "credentials", check: checkCredentialsLoaded},
+	{name: "certificate", check: checkCertificate},
+	{name: "provider", check: checkProviderReachable},
+	{name: "state", check: checkStateStore},
+}
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Time   string                 `json:"time"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// healthzHandlerFunc reports that the process is alive; it does not check any dependency.
+func healthzHandlerFunc(w http.ResponseWriter, _ *http.Request) {
+	writeHealthReport(w, http.StatusOK, &healthReport{
+		Status: checkStatusOK,
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Uptime: time.Since(startTime).Round(time.Second).String(),
+	})
+}
+
+// readyzHandlerFunc runs every readiness check and answers 503 if any of them fails.
+func readyzHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	report := &healthReport{
+		Status: checkStatusOK,
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Uptime: time.Since(startTime).Round(time.Second).String(),
+		Checks: make(map[string]checkResult, len(readinessChecks)),
+	}
+	status := http.StatusOK
+
+	for _, c := range readinessChecks {
+		message, err := c.check(r.Context())
+		switch {
+		case err == nil:
+			report.Checks[c.name] = checkResult{Status: checkStatusOK, Message: message}
+		case err == errCheckSkipped:
+			report.Checks[c.name] = checkResult{Status: checkStatusSkipped, Message: message}
+		default:
+			getLogger().Warnf("Readiness check %s failed: %v", c.name, err)
+			report.Checks[c.name] = checkResult{Status: checkStatusFail, Message: err.Error()}
+			report.Status = checkStatusFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report *healthReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func checkCredentialsLoaded(_ context.Context) (string, error) {
+	if len(validCredentials) == 0 {
+		return "", fmt.Errorf("no credentials loaded")
+	}
+	return fmt.Sprintf("%d entries", len(validCredentials)), nil
+}
+
+// checkCertificate verifies that the configured TLS certificate can be loaded
+// and stays valid for at least cfg.CertMinValidity.
+func checkCertificate(_ context.Context) (string, error) {
+	if !cfg.SSL {
+		return "TLS disabled", errCheckSkipped
+	}
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return "", fmt.Errorf("can not load certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return "", fmt.Errorf("can not parse certificate: %v", err)
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return "", fmt.Errorf("certificate is not valid before %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.Add(cfg.CertMinValidity).After(leaf.NotAfter) {
+		return "", fmt.Errorf("certificate expires at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return "expires at " + leaf.NotAfter.UTC().Format(time.RFC3339), nil
+}
+
+// checkProviderReachable sends a HEAD request to cfg.ReadyProbeURL. Any HTTP
+// response counts as reachable; only transport errors fail the check.
+func checkProviderReachable(ctx context.Context) (string, error) {
+	if cfg.ReadyProbeURL == "" {
+		return "no probe configured", errCheckSkipped
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.ReadyProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cfg.ReadyProbeURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid probe url: %v", err)
+	}
+	resp, err := getUpstreamClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("provider is not reachable: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return fmt.Sprintf("HTTP %d", resp.StatusCode), nil
+}
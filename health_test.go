@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate valid until notAfter
+// and its key to dir
+func writeTestCertificate(t *testing.T, dir string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ddns.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthzHandlerFunc(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var report healthReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if w.Code != http.StatusOK || report.Status != checkStatusOK || report.Checks != nil {
+		t.Errorf("unexpected answer %d %+v", w.Code, report)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("health answers must not be cached")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	savedCfg, savedCredentials, savedClient := cfg, validCredentials, upstreamClient
+	t.Cleanup(func() { cfg, validCredentials, upstreamClient = savedCfg, savedCredentials, savedClient })
+	useMemoryState(t)
+
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("probe expected HEAD but got %s", r.Method)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer probe.Close()
+	// the probe is local, which the default outbound policy refuses
+	upstreamClient = probe.Client()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	dir := t.TempDir()
+	validCert, validKey := writeTestCertificate(t, dir, time.Now().Add(90*24*time.Hour))
+	expiringDir := filepath.Join(dir, "expiring")
+	_ = os.Mkdir(expiringDir, 0o700)
+	expiringCert, expiringKey := writeTestCertificate(t, expiringDir, time.Now().Add(3*24*time.Hour))
+	loaded := map[string]UserInfo{"user": {Password: "password"}}
+
+	testCases := map[string]struct {
+		credentials map[string]UserInfo
+		cfg         ServerConfig
+		status      int
+		checks      map[string]string
+	}{
+		"defaults": {
+			credentials: loaded, status: http.StatusOK,
+			checks: map[string]string{"credentials": checkStatusOK, "certificate": checkStatusSkipped, "provider": checkStatusSkipped, "state": checkStatusSkipped},
+		},
+		"no credentials": {
+			credentials: map[string]UserInfo{}, status: http.StatusServiceUnavailable,
+			checks: map[string]string{"credentials": checkStatusFail},
+		},
+		"valid certificate": {
+			credentials: loaded, cfg: ServerConfig{SSL: true, CertFile: validCert, KeyFile: validKey, CertMinValidity: 14 * 24 * time.Hour},
+			status: http.StatusOK, checks: map[string]string{"certificate": checkStatusOK},
+		},
+		"expiring certificate": {
+			credentials: loaded, cfg: ServerConfig{SSL: true, CertFile: expiringCert, KeyFile: expiringKey, CertMinValidity: 14 * 24 * time.Hour},
+			status: http.StatusServiceUnavailable, checks: map[string]string{"certificate": checkStatusFail},
+		},
+		"missing certificate": {
+			credentials: loaded, cfg: ServerConfig{SSL: true, CertFile: filepath.Join(dir, "none.crt"), KeyFile: validKey},
+			status: http.StatusServiceUnavailable, checks: map[string]string{"certificate": checkStatusFail},
+		},
+		"reachable provider": {
+			credentials: loaded, cfg: ServerConfig{ReadyProbeURL: probe.URL, ReadyProbeTimeout: time.Second},
+			status: http.StatusOK, checks: map[string]string{"provider": checkStatusOK},
+		},
+		"unreachable provider": {
+			credentials: loaded, cfg: ServerConfig{ReadyProbeURL: down.URL, ReadyProbeTimeout: time.Second},
+			status: http.StatusServiceUnavailable, checks: map[string]string{"provider": checkStatusFail},
+		},
+	}
+	for name, testCase := range testCases {
+		testCfg := testCase.cfg
+		cfg, validCredentials = &testCfg, testCase.credentials
+		w := httptest.NewRecorder()
+		readyzHandlerFunc(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report healthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: invalid report: %v", name, err)
+		}
+		if w.Code != testCase.status {
+			t.Errorf("%s expected status %d but got %d: %+v", name, testCase.status, w.Code, report)
+		}
+		for check, status := range testCase.checks {
+			if report.Checks[check].Status != status {
+				t.Errorf("%s expected %s check %s but got %+v", name, check, status, report.Checks[check])
+			}
+		}
+	}
+}
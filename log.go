@@ -1,12 +1,30 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 )
 
 var logger *logrus.Logger
 var lastLogLevel logrus.Level = logrus.InfoLevel
 
+// logOutputCloser is the file or syslog connection opened by configureLogger, if any
+var logOutputCloser io.Closer
+
+const (
+	logFormatText   = "text"
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+
+	logOutputStderr = "stderr"
+	logOutputFile   = "file"
+	logOutputSyslog = "syslog"
+)
+
 func getLogger() *logrus.Logger {
 	if logger == nil {
 		logger = logrus.New()
@@ -19,9 +37,69 @@ func getLogger() *logrus.Logger {
 			ForceColors:   true,
 			FullTimestamp: true,
 		}
-		// Attach the formatter to the logger
-		logger.SetFormatter(formatter)
+		// Attach the formatter to the logger, every line passes the redaction layer
+		logger.SetFormatter(&redactingFormatter{inner: formatter})
 	}
 
 	return logger
 }
+
+// configureLogger applies the log format and destination from the server config.
+// Until it is called the logger writes colored text to stderr.
+func configureLogger(c *ServerConfig) error {
+	var formatter logrus.Formatter
+	switch strings.ToLower(c.LogFormat) {
+	case "", logFormatText:
+		formatter = &logrus.TextFormatter{ForceColors: c.LogOutput == "" || c.LogOutput == logOutputStderr, FullTimestamp: true}
+	case logFormatLogfmt:
+		formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	case logFormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+
+	var (
+		output io.Writer
+		closer io.Closer
+	)
+	switch strings.ToLower(c.LogOutput) {
+	case "", logOutputStderr:
+		output = os.Stderr
+	case logOutputFile:
+		if c.LogFile == "" {
+			return fmt.Errorf("log output is file but log-file is not set")
+		}
+		rf, err := newRotatingFile(c.LogFile, c.LogMaxSize, c.LogMaxAge, c.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		output, closer = rf, rf
+	case logOutputSyslog:
+		hook, err := newSyslogHook(c.SyslogAddress, c.SyslogTag)
+		if err != nil {
+			return err
+		}
+		getLogger().AddHook(hook)
+		output, closer = io.Discard, hook
+	default:
+		return fmt.Errorf("unknown log output %q", c.LogOutput)
+	}
+
+	getLogger().SetFormatter(&redactingFormatter{inner: formatter})
+	getLogger().SetOutput(output)
+	if logOutputCloser != nil {
+		_ = logOutputCloser.Close()
+	}
+	logOutputCloser = closer
+	return nil
+}
+
+// closeLogger flushes and closes the log file or syslog connection
+func closeLogger() {
+	if logOutputCloser != nil {
+		getLogger().SetOutput(os.Stderr)
+		_ = logOutputCloser.Close()
+		logOutputCloser = nil
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redactedMask = "***"
+
+// Secrets shorter than this are not masked by value, they would match too many unrelated strings
+const minSecretLength = 3
+
+var secretPatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	// user:password@ in URLs
+	{regexp.MustCompile(`(://[^:/@\s]+:)[^@\s/]+@`), "${1}" + redactedMask + "@"},
+	// password=..., token=... in query strings and form bodies
+	{regexp.MustCompile(`(?i)\b((?:password|passwd|pass|pwd|dd-?pass|token|secret|api[-_]?key|key|signature)=)[^&\s"]+`), "${1}" + redactedMask},
+	// Authorization header values
+	{regexp.MustCompile(`\b((?:Basic|Bearer|[Aa]pikey)\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + redactedMask},
+	// "password": "..." in JSON documents
+	{regexp.MustCompile(`(?i)("(?:password|dd-pass|token|secret|api[-_]?key|key)"\s*:\s*")[^"]*"`), "${1}" + redactedMask + `"`},
+}
+
+var (
+	secretsMu       sync.RWMutex
+	secretValues    = make(map[string]struct{})
+	secretsReplacer *strings.Replacer
+)
+
+// registerSecrets adds values that must never appear in logs, such as passwords and API tokens
+func registerSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			secretValues[v] = struct{}{}
+		}
+	}
+	rebuildSecretsReplacer()
+}
+
+// replaceSecret swaps a registered secret that is renewed, such as a short
+// lived access token, for its successor so the old values do not pile up.
+// An empty next only removes previous.
+func replaceSecret(previous, next string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	delete(secretValues, previous)
+	if len(next) >= minSecretLength {
+		secretValues[next] = struct{}{}
+	}
+	rebuildSecretsReplacer()
+}
+
+// rebuildSecretsReplacer builds the replacer of the registered secrets, the
+// caller holds secretsMu
+func rebuildSecretsReplacer() {
+	// Longest secrets first, so a secret containing another one is masked as a whole
+	sorted := make([]string, 0, len(secretValues))
+	for v := range secretValues {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		pairs = append(pairs, v, redactedMask)
+	}
+	secretsReplacer = strings.NewReplacer(pairs...)
+}
+
+// redact masks registered secret values and well-known secret shapes in s
+func redact(s string) string {
+	secretsMu.RLock()
+	replacer := secretsReplacer
+	secretsMu.RUnlock()
+	if replacer != nil {
+		s = replacer.Replace(s)
+	}
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// redactingFormatter masks secrets in the message and fields of every entry
+// before handing it to the real formatter, whatever the level is.
+type redactingFormatter struct {
+	inner logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	clean := *entry
+	clean.Message = redact(entry.Message)
+	clean.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			clean.Data[k] = redact(value)
+		case error:
+			clean.Data[k] = redact(value.Error())
+		case fmt.Stringer:
+			clean.Data[k] = redact(value.String())
+		default:
+			// other values (slices, maps, structs) are checked in their printed
+			// form and only replaced when it holds a secret, so numbers and
+			// such keep their type in the formatted line
+			if text := fmt.Sprint(v); redact(text) != text {
+				clean.Data[k] = redact(text)
+			} else {
+				clean.Data[k] = v
+			}
+		}
+	}
+	return f.inner.Format(&clean)
+}
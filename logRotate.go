@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedFileTimeFormat = "20060102-150405.000"
+
+// rotatingFile is an io.Writer that appends to a log file and moves it aside
+// once it grows beyond maxSize bytes or gets older than maxAge. Only the
+// newest maxBackups rotated files are kept. A zero limit disables that rule.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0o755); err != nil {
+		return fmt.Errorf("can not create log directory: %v", err)
+	}
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("can not open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("can not stat log file: %v", err)
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = info.ModTime()
+	if rf.size == 0 {
+		rf.opened = time.Now()
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.needsRotation(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) needsRotation(next int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.maxSize > 0 && rf.size+next > rf.maxSize {
+		return true
+	}
+	return rf.maxAge > 0 && time.Since(rf.opened) > rf.maxAge
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	rotated := rf.rotatedName(time.Now())
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return fmt.Errorf("can not rotate log file: %v", err)
+	}
+	rf.removeOldBackups()
+	return rf.open()
+}
+
+// rotatedName returns a free backup name for a rotation at now; rotations
+// within the same millisecond get a counter
+func (rf *rotatingFile) rotatedName(now time.Time) string {
+	base := rf.path + "." + now.Format(rotatedFileTimeFormat)
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// rotatedBackup is a file moved aside by rotate
+type rotatedBackup struct {
+	name    string
+	at      time.Time
+	counter int
+}
+
+// parseRotatedSuffix reads the time and counter of a backup name suffix,
+// reporting false for files rotate did not create
+func parseRotatedSuffix(suffix string) (time.Time, int, bool) {
+	if len(suffix) < len(rotatedFileTimeFormat) {
+		return time.Time{}, 0, false
+	}
+	at, err := time.Parse(rotatedFileTimeFormat, suffix[:len(rotatedFileTimeFormat)])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	counter := 0
+	if rest := suffix[len(rotatedFileTimeFormat):]; rest != "" {
+		if !strings.HasPrefix(rest, "-") {
+			return time.Time{}, 0, false
+		}
+		if counter, err = strconv.Atoi(rest[1:]); err != nil || counter < 1 {
+			return time.Time{}, 0, false
+		}
+	}
+	return at, counter, true
+}
+
+func (rf *rotatingFile) removeOldBackups() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+	// Other files next to the log, e.g. compressed or lock files, are left alone
+	var backups []rotatedBackup
+	for _, name := range matches {
+		if at, counter, ok := parseRotatedSuffix(strings.TrimPrefix(name, rf.path+".")); ok {
+			backups = append(backups, rotatedBackup{name: name, at: at, counter: counter})
+		}
+	}
+	if len(backups) <= rf.maxBackups {
+		return
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].at.Equal(backups[j].at) {
+			return backups[i].at.Before(backups[j].at)
+		}
+		return backups[i].counter < backups[j].counter
+	})
+	for _, old := range backups[:len(backups)-rf.maxBackups] {
+		_ = os.Remove(old.name)
+	}
+}
+
+func (rf *rotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	_ = rf.file.Sync()
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "ddns.log")
+	rf, err := newRotatingFile(path, 20, 0, 2)
+	if err != nil {
+		t.Fatalf("newRotatingFile failed: %v", err)
+	}
+	defer func() { _ = rf.Close() }()
+
+	for i := 0; i < 5; i++ {
+		if _, err := rf.Write([]byte("0123456789abcdef\n")); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+		// rotated names have millisecond timestamps
+		time.Sleep(2 * time.Millisecond)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "0123456789abcdef\n" {
+		t.Errorf("expected one line in the current file but got %q", current)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("expected 2 backups but got %v", backups)
+	}
+	for _, backup := range backups {
+		if content, _ := os.ReadFile(backup); strings.Count(string(content), "\n") != 1 {
+			t.Errorf("backup %s expected one line but got %q", backup, content)
+		}
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddns.log")
+	rf, err := newRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("newRotatingFile failed: %v", err)
+	}
+	defer func() { _ = rf.Close() }()
+
+	_, _ = rf.Write([]byte("first\n"))
+	_, _ = rf.Write([]byte("second\n"))
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 0 {
+		t.Fatalf("a young file must not rotate but got %v", backups)
+	}
+	rf.opened = time.Now().Add(-2 * time.Hour)
+	_, _ = rf.Write([]byte("third\n"))
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup but got %v", backups)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != "first\nsecond\n" {
+		t.Errorf("unexpected backup content %q", content)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "third\n" {
+		t.Errorf("unexpected current content %q", content)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddns.log")
+	_ = os.WriteFile(path, []byte("earlier\n"), 0o640)
+	rf, err := newRotatingFile(path, 1024, 0, 0)
+	if err != nil {
+		t.Fatalf("newRotatingFile failed: %v", err)
+	}
+	if rf.size != int64(len("earlier\n")) {
+		t.Errorf("existing size expected %d but got %d", len("earlier\n"), rf.size)
+	}
+	_ = rf.Close()
+	// writes after Close open the file again
+	_, _ = rf.Write([]byte("later\n"))
+	_ = rf.Close()
+	if content, _ := os.ReadFile(path); string(content) != "earlier\nlater\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestRotatingFileBackupNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	others := []string{"access.log.gz", "access.log.lock", "access.log.20060102-150405.000.gz", "access.log.old"}
+	for _, name := range others {
+		_ = os.WriteFile(filepath.Join(dir, name), []byte("keep\n"), 0o640)
+	}
+	rf, err := newRotatingFile(path, 0, 0, 3)
+	if err != nil {
+		t.Fatalf("newRotatingFile failed: %v", err)
+	}
+	defer func() { _ = rf.Close() }()
+
+	// rotations within the same millisecond keep every backup
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		_, _ = rf.Write([]byte("line\n"))
+		_ = rf.Close()
+		if err := os.Rename(path, rf.rotatedName(now)); err != nil {
+			t.Fatalf("rename failed: %v", err)
+		}
+		rf.removeOldBackups()
+	}
+	for i, suffix := range []string{"", "-1", "-2"} {
+		if _, err := os.Stat(path + "." + now.Format(rotatedFileTimeFormat) + suffix); err != nil {
+			t.Errorf("backup %d expected but got %v", i, err)
+		}
+	}
+
+	// only rotated files count as backups and are removed
+	_, _ = rf.Write([]byte("line\n"))
+	if err := rf.rotate(); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s must not be removed: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + "." + now.Format(rotatedFileTimeFormat)); !os.IsNotExist(err) {
+		t.Errorf("the oldest backup expected to be removed but got %v", err)
+	}
+}
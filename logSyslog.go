@@ -0,0 +1,65 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogHook sends every entry to syslog with a priority matching its level
+type syslogHook struct {
+	writer *syslog.Writer
+}
+
+// newSyslogHook connects to the local syslog daemon when address is empty, or
+// to a remote one given as udp://host:514, tcp://host:514 or unix:///dev/log.
+func newSyslogHook(address string, tag string) (*syslogHook, error) {
+	var network, raddr string
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address %q: %v", address, err)
+		}
+		network = u.Scheme
+		raddr = u.Host
+		if network == "unix" || network == "unixgram" {
+			raddr = u.Path
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("can not connect to syslog: %v", err)
+	}
+	return &syslogHook{writer: w}, nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.writer.Crit(msg)
+	case logrus.ErrorLevel:
+		return h.writer.Err(msg)
+	case logrus.WarnLevel:
+		return h.writer.Warning(msg)
+	case logrus.InfoLevel:
+		return h.writer.Info(msg)
+	default:
+		return h.writer.Debug(msg)
+	}
+}
+
+func (h *syslogHook) Close() error {
+	return h.writer.Close()
+}
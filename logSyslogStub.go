@@ -0,0 +1,27 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+type syslogHook struct{}
+
+func newSyslogHook(_ string, _ string) (*syslogHook, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return nil
+}
+
+func (h *syslogHook) Fire(_ *logrus.Entry) error {
+	return nil
+}
+
+func (h *syslogHook) Close() error {
+	return nil
+}
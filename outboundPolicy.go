@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const maxOutboundRedirects = 5
+
+// OutboundPolicy limits where provider calls may go. URL templates are filled
+// with request and configuration values, so the policy is enforced on the
+// final request and again on the address actually dialed, which also covers
+// DNS names that resolve to internal addresses and redirects.
+type OutboundPolicy struct {
+	// Schemes allowed in provider URLs
+	Schemes []string
+	// AllowedHosts lists hostnames and parent domains (".example.com" or
+	// "example.com" for the domain and its subdomains); empty allows any host
+	AllowedHosts []string
+	// AllowPrivate permits loopback, private, link-local and other
+	// non-public destinations
+	AllowPrivate bool
+}
+
+var defaultOutboundPolicy = OutboundPolicy{
+	Schemes: []string{"https", "http"},
+}
+
+// errOutboundBlocked wraps every refusal of the outbound policy
+var errOutboundBlocked = errors.New("blocked by outbound policy")
+
+// Non-public ranges that net.IP has no predicate for
+var extraBlockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier-grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved
+	"64:ff9b:1::/48", // local-use NAT64
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	for _, network := range extraBlockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOutboundPolicy builds a policy from comma separated lists; empty lists keep the defaults of base
+func parseOutboundPolicy(base OutboundPolicy, schemes string, allowedHosts string, allowPrivate *bool) (*OutboundPolicy, error) {
+	policy := base
+	if list := splitList(schemes); len(list) > 0 {
+		for _, scheme := range list {
+			if scheme != "http" && scheme != "https" {
+				return nil, fmt.Errorf("unsupported outbound scheme %q", scheme)
+			}
+		}
+		policy.Schemes = list
+	}
+	if list := splitList(allowedHosts); len(list) > 0 {
+		policy.AllowedHosts = list
+	}
+	if allowPrivate != nil {
+		policy.AllowPrivate = *allowPrivate
+	}
+	return &policy, nil
+}
+
+// splitList splits a comma or space separated list, lower-casing its entries
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		list = append(list, strings.ToLower(entry))
+	}
+	return list
+}
+
+// checkURL refuses URLs with a scheme or host the policy does not allow
+func (p *OutboundPolicy) checkURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	allowed := false
+	for _, s := range p.Schemes {
+		if s == scheme {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: scheme %q is not allowed", errOutboundBlocked, u.Scheme)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("%w: url has no host", errOutboundBlocked)
+	}
+	if ip := net.ParseIP(host); ip != nil && !p.AllowPrivate && !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", errOutboundBlocked, host)
+	}
+	if len(p.AllowedHosts) == 0 {
+		return nil
+	}
+	for _, entry := range p.AllowedHosts {
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host %s is not allowed", errOutboundBlocked, host)
+}
+
+// checkIP refuses to connect to non-public addresses unless the policy allows them
+func (p *OutboundPolicy) checkIP(ip net.IP) error {
+	if !p.AllowPrivate && !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", errOutboundBlocked, ip)
+	}
+	return nil
+}
+
+type outboundPolicyContextKey struct{}
+
+// withOutboundPolicy makes provider calls made with ctx use p instead of the global policy
+func withOutboundPolicy(ctx context.Context, p *OutboundPolicy) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outboundPolicyContextKey{}, p)
+}
+
+// outboundPolicyFromContext returns the policy attached to ctx, or the global one
+func outboundPolicyFromContext(ctx context.Context, global *OutboundPolicy) *OutboundPolicy {
+	if p, ok := ctx.Value(outboundPolicyContextKey{}).(*OutboundPolicy); ok {
+		return p
+	}
+	if global != nil {
+		return global
+	}
+	return &defaultOutboundPolicy
+}
+
+// policyRoundTripper checks every request, including each redirect hop,
+// against the outbound policy. The dialed address is only checked when a
+// connection is opened, so requests of policies allowing private
+// destinations use their own transport: a connection dialed for them stays
+// out of the pool that requests of stricter policies reuse.
+type policyRoundTripper struct {
+	next    *http.Transport
+	private *http.Transport
+	global  *OutboundPolicy
+}
+
+func (t *policyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := outboundPolicyFromContext(req.Context(), t.global)
+	if err := policy.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	if policy.AllowPrivate && t.private != nil {
+		return t.private.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// checkOutboundRedirect refuses redirects to targets the policy does not allow
+func checkOutboundRedirect(global *OutboundPolicy) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxOutboundRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxOutboundRedirects)
+		}
+		if err := outboundPolicyFromContext(req.Context(), global).checkURL(req.URL); err != nil {
+			return fmt.Errorf("refused redirect to %s: %w", redact(req.URL.String()), err)
+		}
+		return nil
+	}
+}
+
+// guardedDialContext dials through dialer, refusing non-public addresses after
+// name resolution. Connections to the configured proxies are not checked, the
+// proxy resolves the real destination.
+func guardedDialContext(dialer *net.Dialer, global *OutboundPolicy, globalProxy *ProxySettings) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		policy := outboundPolicyFromContext(ctx, global)
+		if policy.AllowPrivate || isProxyAddress(ctx, globalProxy, addr) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		d := *dialer
+		d.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: can not parse dialed address %s", errOutboundBlocked, address)
+			}
+			return policy.checkIP(ip)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+func isProxyAddress(ctx context.Context, globalProxy *ProxySettings, addr string) bool {
+	var proxies []*url.URL
+	if p, ok := ctx.Value(upstreamProxyContextKey{}).(*ProxySettings); ok && p.URL != nil {
+		proxies = append(proxies, p.URL)
+	}
+	if globalProxy != nil && globalProxy.URL != nil {
+		proxies = append(proxies, globalProxy.URL)
+	} else if globalProxy == nil {
+		for _, env := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+			if u, err := url.Parse(os.Getenv(env)); err == nil && u.Host != "" {
+				proxies = append(proxies, u)
+			}
+		}
+	}
+	for _, u := range proxies {
+		if proxyHostPort(u) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[strings.ToLower(u.Scheme)]
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOutboundPolicyCheckURL(t *testing.T) {
+	policy, err := parseOutboundPolicy(defaultOutboundPolicy, "https", "example.com, api.cloudflare.com", nil)
+	if err != nil {
+		t.Fatalf("parseOutboundPolicy failed: %v", err)
+	}
+
+	testCases := map[string]bool{
+		"https://example.com/nic/update":           true,
+		"https://dyn.example.com/nic/update":       true,
+		"https://api.cloudflare.com/client/v4":     true,
+		"https://cloudflare.com/":                  false,
+		"https://evil-example.com/":                false,
+		"http://example.com/":                      false,
+		"file:///etc/passwd":                       false,
+		"https://127.0.0.1/":                       false,
+		"https://169.254.169.254/latest/meta-data": false,
+		"https://[::1]/":                           false,
+		"https://10.1.2.3/":                        false,
+	}
+
+	for rawURL, allowed := range testCases {
+		u, _ := url.Parse(rawURL)
+		if err := policy.checkURL(u); (err == nil) != allowed {
+			t.Errorf("checkURL(%s) expected allowed=%v but got %v", rawURL, allowed, err)
+		}
+	}
+}
+
+func TestOutboundPolicyDialTime(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+
+	client, err := newUpstreamClient(&defaultUpstreamConfig)
+	if err != nil {
+		t.Fatalf("newUpstreamClient failed: %v", err)
+	}
+
+	// localhost is only known to resolve to a loopback address at dial time
+	internalURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	req, _ := http.NewRequest(http.MethodGet, internalURL, nil)
+	if _, err := client.Do(req); !errors.Is(err, errOutboundBlocked) {
+		t.Errorf("request to a loopback address was not blocked: %v", err)
+	}
+
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+	req, _ = http.NewRequestWithContext(withOutboundPolicy(context.Background(), policy), http.MethodGet, internalURL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request allowed by the entry policy failed: %v", err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestOutboundPolicyPooledConnections(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+
+	client, err := newUpstreamClient(&defaultUpstreamConfig)
+	if err != nil {
+		t.Fatalf("newUpstreamClient failed: %v", err)
+	}
+	internalURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	allowPrivate, restricted := true, false
+	privatePolicy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+	restrictedPolicy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &restricted)
+
+	call := func(policy *OutboundPolicy) error {
+		req, _ := http.NewRequestWithContext(withOutboundPolicy(context.Background(), policy), http.MethodGet, internalURL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		// read to the end so the connection goes back to the pool
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	// the entry allowing private destinations leaves an idle connection to the host
+	if err := call(privatePolicy); err != nil {
+		t.Fatalf("request allowed by the entry policy failed: %v", err)
+	}
+	if err := call(restrictedPolicy); !errors.Is(err, errOutboundBlocked) {
+		t.Errorf("restricted entry reused a connection to a loopback address: %v", err)
+	}
+	if err := call(privatePolicy); err != nil {
+		t.Errorf("second request allowed by the entry policy failed: %v", err)
+	}
+}
+
+func TestOutboundPolicyRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/update" {
+			http.Redirect(w, r, "http://localhost"+strings.TrimPrefix(r.Host, "127.0.0.1")+"/internal", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+
+	client, err := newUpstreamClient(&defaultUpstreamConfig)
+	if err != nil {
+		t.Fatalf("newUpstreamClient failed: %v", err)
+	}
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "127.0.0.1", &allowPrivate)
+
+	req, _ := http.NewRequestWithContext(withOutboundPolicy(context.Background(), policy), http.MethodGet, target.URL+"/update", nil)
+	if _, err := client.Do(req); !errors.Is(err, errOutboundBlocked) {
+		t.Errorf("redirect to a host outside the allowlist was followed: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ResultCode is the outcome of an update, using the dyndns2 return codes
+// most DDNS clients already understand.
+type ResultCode string
+
+const (
+	ResultGood        ResultCode = "good"
+	ResultNoChange    ResultCode = "nochg"
+	ResultBadAuth     ResultCode = "badauth"
+	ResultNotFQDN     ResultCode = "notfqdn"
+	ResultNoHost      ResultCode = "nohost"
+	ResultAbuse       ResultCode = "abuse"
+	ResultBadAgent    ResultCode = "badagent"
+	ResultDNSError    ResultCode = "dnserr"
+	ResultServerError ResultCode = "911"
+)
+
+// resultHeader carries the ResultCode of every update response
+const resultHeader = "X-DDNS-Result"
+
+const (
+	providerURL     = "url"
+	providerRelay   = "relay"
+	providerWebhook = "webhook"
+)
+
+// UpdateRequest is a validated update handed to a provider
+type UpdateRequest struct {
+	Host   string
+	IPs    []net.IP
+	Force  bool
+	Creds  *UserInfo
+	Params map[string]interface{}
+
+	// Relay hop state of the incoming request
+	Hops int
+	Via  []string
+}
+
+// IPv4 returns the first IPv4 address of the update, or an empty string
+func (u *UpdateRequest) IPv4() string {
+	for _, ip := range u.IPs {
+		if ip.To4() != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// IPv6 returns the first IPv6 address of the update, or an empty string
+func (u *UpdateRequest) IPv6() string {
+	for _, ip := range u.IPs {
+		if ip.To4() == nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// IPStrings returns all addresses of the update in request order
+func (u *UpdateRequest) IPStrings() []string {
+	ips := make([]string, 0, len(u.IPs))
+	for _, ip := range u.IPs {
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// addressRecord is the address record type and value for one address family of an update
+type addressRecord struct {
+	Type string
+	IP   string
+}
+
+// AddressRecords returns the A and AAAA records the update sets, IPv4 first
+func (u *UpdateRequest) AddressRecords() []addressRecord {
+	var records []addressRecord
+	if ip := u.IPv4(); ip != "" {
+		records = append(records, addressRecord{Type: "A", IP: ip})
+	}
+	if ip := u.IPv6(); ip != "" {
+		records = append(records, addressRecord{Type: "AAAA", IP: ip})
+	}
+	return records
+}
+
+// zoneCandidates returns host and its parent domains from the longest down to
+// the second level, the zones a record of host may be in
+func zoneCandidates(host string) []string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	var candidates []string
+	for i := 0; i < len(labels)-1; i++ {
+		candidates = append(candidates, strings.Join(labels[i:], "."))
+	}
+	return candidates
+}
+
+// relativeRecordName returns host relative to zone, "@" for the zone apex
+func relativeRecordName(host, zone string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(host, "."+zone)
+}
+
+// txtProvider is implemented by providers that can also set the TXT record of
+// a host, e.g. for DNS-01 challenges. An empty value clears the record.
+type txtProvider interface {
+	UpdateTXT(ctx context.Context, host, value string) (*UpdateResult, error)
+}
+
+// UpdateResult is what a provider answered
+type UpdateResult struct {
+	Code ResultCode
+	// StatusCode is the HTTP status of the provider response, 0 if the provider does not speak HTTP
+	StatusCode int
+	Body       string
+	// Verbatim results are written to the client as they are, e.g. a downstream relay response
+	Verbatim bool
+	// Providers holds the result of each provider of a multi provider
+	Providers []namedResult
+}
+
+// namedResult is the result of one of the providers of a multi provider
+type namedResult struct {
+	Name string
+	UpdateResult
+}
+
+// Success reports whether the record now holds the requested addresses
+func (r *UpdateResult) Success() bool {
+	return r.Code == ResultGood || r.Code == ResultNoChange
+}
+
+// Provider updates the records of a host. An error means the provider could
+// not be asked at all; answers of the provider, including refusals, are
+// reported through the UpdateResult.
+type Provider interface {
+	Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error)
+}
+
+// providerFactory builds the provider of a credential entry from its JSON object
+type providerFactory func(username string, entry gjson.Result) (Provider, error)
+
+var providerFactories = map[string]providerFactory{
+	providerURL:          newURLProvider,
+	providerRelay:        newRelayProvider,
+	providerWebhook:      newWebhookProvider,
+	providerCloudflare:   newCloudflareProvider,
+	providerRFC2136:      newRFC2136Provider,
+	providerRoute53:      newRoute53Provider,
+	providerArvanCloud:   newArvanCloudProvider,
+	providerNamecheap:    newNamecheapProvider,
+	providerDuckDNS:      newDuckDNSProvider,
+	providerPowerDNS:     newPowerDNSProvider,
+	providerGcloud:       newGcloudProvider,
+	providerOVH:          newOVHProvider,
+	providerGandi:        newGandiProvider,
+	providerDigitalOcean: newDigitalOceanProvider,
+	providerHetzner:      newHetznerProvider,
+	providerLinode:       newLinodeProvider,
+	providerDeSEC:        newDeSECProvider,
+	providerPorkbun:      newPorkbunProvider,
+	providerDyndns2:      newDyndns2Provider(providerDyndns2),
+	providerNoIP:         newDyndns2Provider(providerNoIP),
+	providerDyn:          newDyndns2Provider(providerDyn),
+	providerDynu:         newDyndns2Provider(providerDynu),
+	providerHE:           newDyndns2Provider(providerHE),
+	providerFreeDNS:      newDyndns2Provider(providerFreeDNS),
+}
+
+// providerAPIError is a refusal of a provider API; it is answered to the
+// client as an UpdateResult rather than as a failed provider call
+type providerAPIError struct {
+	Code       ResultCode
+	StatusCode int
+	Message    string
+}
+
+func (e *providerAPIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// resultFromError turns a providerAPIError into its UpdateResult and returns other errors as they are
+func resultFromError(err error) (*UpdateResult, error) {
+	var apiErr *providerAPIError
+	if errors.As(err, &apiErr) {
+		return &UpdateResult{Code: apiErr.Code, StatusCode: apiErr.StatusCode, Body: apiErr.Message}, nil
+	}
+	return nil, err
+}
+
+// httpStatusResultCode maps the status of a failed provider API call to a result code
+func httpStatusResultCode(status int) ResultCode {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ResultBadAuth
+	case http.StatusNotFound:
+		return ResultNoHost
+	}
+	return ResultServerError
+}
+
+// doProviderRequest sends a provider request with the shared upstream client
+// and reads the whole response
+func doProviderRequest(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := getUpstreamClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	return resp, body, nil
+}
+
+// jsonCall is a call of the JSON API of a provider
+type jsonCall struct {
+	// provider names the API in logs and errors
+	provider string
+	method   string
+	url      string
+	// query is appended to url unless it is empty
+	query url.Values
+	// header holds the authentication and any other header of the call
+	header http.Header
+	// in is sent as the JSON body unless it is nil
+	in interface{}
+}
+
+// doJSONCall sends call, honoring limit unless it is nil, and returns the
+// answer of a successful call. Other answers are returned as a
+// providerAPIError with the code of the HTTP status and the body as message;
+// explain, if not nil, then reads the message and code from the body.
+func doJSONCall(ctx context.Context, limit *providerRateLimit, call jsonCall, explain func(apiErr *providerAPIError, data []byte)) ([]byte, error) {
+	if limit != nil {
+		if err := limit.check(); err != nil {
+			return nil, err
+		}
+	}
+	var body io.Reader
+	if call.in != nil {
+		data, err := json.Marshal(call.in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	target := call.url
+	if len(call.query) > 0 {
+		target += "?" + call.query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, call.method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("error building %s request: %v", call.provider, err)
+	}
+	for key, values := range call.header {
+		httpReq.Header[key] = values
+	}
+	if call.in != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	getLogger().Debugf("Calling %s %s %s", call.provider, call.method, target)
+	resp, data, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %v", call.provider, err)
+	}
+	if limit != nil {
+		limit.observe(resp)
+	}
+	if resp.StatusCode/100 != 2 {
+		apiErr := &providerAPIError{Code: httpStatusResultCode(resp.StatusCode), StatusCode: resp.StatusCode, Message: string(data)}
+		if explain != nil {
+			explain(apiErr, data)
+		}
+		return nil, apiErr
+	}
+	return data, nil
+}
+
+// newProvider builds the provider named in a credential entry, the url provider by default
+func newProvider(username string, entry gjson.Result) (Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(entry.Get("provider").String()))
+	if name == "" {
+		name = providerURL
+	}
+	// the multi provider builds its providers with newProvider, so it is not
+	// in providerFactories to keep the map initialization acyclic
+	if name == providerMulti {
+		return newMultiProvider(username, entry)
+	}
+	factory, ok := providerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	return factory(username, entry)
+}
+
+// writeUpdateResult answers the client with the provider result. The first
+// line is "OK <status>" or "Fail: <status>" followed by the provider answer.
+func writeUpdateResult(w http.ResponseWriter, result *UpdateResult) {
+	w.Header().Set(resultHeader, string(result.Code))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if result.Verbatim {
+		if result.StatusCode != 0 {
+			w.WriteHeader(result.StatusCode)
+		}
+		_, _ = w.Write([]byte(result.Body))
+		return
+	}
+
+	status := fmt.Sprint(result.StatusCode)
+	if result.StatusCode == 0 {
+		status = string(result.Code)
+	}
+	if result.Success() {
+		getLogger().Infof("Respons: %s\n%s", status, result.Body)
+		_, _ = fmt.Fprintf(w, "OK %s\n%s", status, result.Body)
+	} else {
+		getLogger().Warnf("Respons: %s\n%s", status, result.Body)
+		_, _ = fmt.Fprintf(w, "Fail: %s\n%s", status, result.Body)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerArvanCloud = "arvancloud"
+
+	defaultArvanCloudAPI = "https://napi.arvancloud.ir/cdn/4.0"
+	defaultArvanCloudTTL = 120
+)
+
+// arvanCloudProvider updates the A and AAAA records of a host through the
+// ArvanCloud CDN DNS API, authenticating with a machine user API key. The
+// TTL and cloud (proxied) flag of existing records are kept unless set in
+// the entry.
+type arvanCloudProvider struct {
+	api    string
+	apiKey string
+	domain string
+	ttl    int64
+	cloud  *bool
+}
+
+type arvanCloudRecord struct {
+	ID    string                  `json:"id,omitempty"`
+	Type  string                  `json:"type"`
+	Name  string                  `json:"name"`
+	Value []arvanCloudRecordValue `json:"value"`
+	TTL   int64                   `json:"ttl"`
+	Cloud bool                    `json:"cloud"`
+}
+
+type arvanCloudRecordValue struct {
+	IP string `json:"ip"`
+}
+
+func newArvanCloudProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &arvanCloudProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		apiKey: strings.TrimSpace(entry.Get("api-key").String()),
+		domain: strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:    entry.Get("ttl").Int(),
+	}
+	if p.api == "" {
+		p.api = defaultArvanCloudAPI
+	}
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("arvancloud provider of %s needs an api-key", username)
+	}
+	// keys are shown in the panel as "Apikey <uuid>"
+	if !strings.HasPrefix(strings.ToLower(p.apiKey), "apikey ") {
+		p.apiKey = "Apikey " + p.apiKey
+	}
+	if cloud := entry.Get("cloud"); cloud.Exists() {
+		value := cloud.Bool()
+		p.cloud = &value
+	}
+	if p.ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl of %s", username)
+	}
+	registerSecrets(p.apiKey, strings.TrimSpace(p.apiKey[len("Apikey "):]))
+	return p, nil
+}
+
+func (p *arvanCloudProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	name := relativeRecordName(req.Host, domain)
+
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, domain, name, strings.ToLower(record.Type), record.IP)
+		if err != nil {
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// domainFor finds the ArvanCloud domain of host, trying its parent domains from the longest
+func (p *arvanCloudProvider) domainFor(ctx context.Context, host string) (string, error) {
+	if p.domain != "" {
+		return p.domain, nil
+	}
+	key := "arvancloud/domain/" + host
+	var domain string
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	for _, name := range zoneCandidates(host) {
+		_, err := p.do(ctx, http.MethodGet, "/domains/"+url.PathEscape(name), nil, nil)
+		if err == nil {
+			getState().Set(key, name, 24*time.Hour)
+			return name, nil
+		}
+		var apiErr *providerAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			return "", err
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no ArvanCloud domain found for " + host}
+}
+
+// upsert sets the record of name to ip, creating it if needed, and reports whether anything changed
+func (p *arvanCloudProvider) upsert(ctx context.Context, domain, name, recordType, ip string) (bool, error) {
+	var current *arvanCloudRecord
+	path := "/domains/" + url.PathEscape(domain) + "/dns-records"
+	for page := 1; current == nil; page++ {
+		query := url.Values{"type": {recordType}, "search": {name}, "page": {fmt.Sprint(page)}, "per_page": {"100"}}
+		data, err := p.do(ctx, http.MethodGet, path, query, nil)
+		if err != nil {
+			return false, err
+		}
+		var records []arvanCloudRecord
+		if err := json.Unmarshal([]byte(gjson.GetBytes(data, "data").Raw), &records); err != nil {
+			return false, fmt.Errorf("invalid ArvanCloud answer: %v", err)
+		}
+		for i := range records {
+			if records[i].Type == recordType && records[i].Name == name {
+				current = &records[i]
+				break
+			}
+		}
+		if gjson.GetBytes(data, "meta.current_page").Int() >= gjson.GetBytes(data, "meta.last_page").Int() {
+			break
+		}
+	}
+
+	record := arvanCloudRecord{Type: recordType, Name: name, Value: []arvanCloudRecordValue{{IP: ip}}, TTL: defaultArvanCloudTTL}
+	if current != nil {
+		record.TTL, record.Cloud = current.TTL, current.Cloud
+	}
+	if p.ttl > 0 {
+		record.TTL = p.ttl
+	}
+	if p.cloud != nil {
+		record.Cloud = *p.cloud
+	}
+	if current == nil {
+		_, err := p.do(ctx, http.MethodPost, path, nil, record)
+		return err == nil, err
+	}
+	if len(current.Value) == 1 && current.Value[0].IP == ip && current.TTL == record.TTL && current.Cloud == record.Cloud {
+		return false, nil
+	}
+	_, err := p.do(ctx, http.MethodPut, path+"/"+url.PathEscape(current.ID), nil, record)
+	return err == nil, err
+}
+
+// do calls the API; invalid records (422) and throttled calls are 911
+func (p *arvanCloudProvider) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	call := jsonCall{provider: "ArvanCloud", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {p.apiKey}, "Accept": {"application/json"}}}
+	return doJSONCall(ctx, nil, call, func(apiErr *providerAPIError, data []byte) {
+		if message := gjson.GetBytes(data, "message").String(); message != "" {
+			apiErr.Message = message
+		}
+		if apiErr.StatusCode == http.StatusUnprocessableEntity || apiErr.StatusCode == http.StatusTooManyRequests {
+			apiErr.Code = ResultServerError
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestArvanCloudProvider(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": "r1", "type": "a", "name": "www", "value": []interface{}{map[string]interface{}{"ip": "198.51.100.9"}}, "ttl": 120, "cloud": false},
+		{"id": "r2", "type": "a", "name": "home", "value": []interface{}{map[string]interface{}{"ip": "198.51.100.1"}}, "ttl": 600, "cloud": true},
+	}
+	var writes []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Apikey 1234-abcd" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "Unauthenticated."}`))
+			return
+		}
+		switch {
+		case r.URL.Path == "/cdn/4.0/domains/example.com":
+			_, _ = w.Write([]byte(`{"data": {"domain": "example.com"}}`))
+		case strings.HasPrefix(r.URL.Path, "/cdn/4.0/domains/") && !strings.Contains(r.URL.Path, "/dns-records"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Domain not found"}`))
+		case r.URL.Path == "/cdn/4.0/domains/example.com/dns-records" && r.Method == http.MethodGet:
+			// one record per page
+			page := 0
+			if r.URL.Query().Get("page") == "2" {
+				page = 1
+			}
+			found := []interface{}{}
+			if records[page]["type"] == r.URL.Query().Get("type") {
+				found = append(found, records[page])
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": found, "meta": map[string]int{"current_page": page + 1, "last_page": 2}})
+		case r.URL.Path == "/cdn/4.0/domains/example.com/dns-records" && r.Method == http.MethodPost,
+			r.URL.Path == "/cdn/4.0/domains/example.com/dns-records/r2" && r.Method == http.MethodPut:
+			var record arvanCloudRecord
+			_ = json.NewDecoder(r.Body).Decode(&record)
+			if record.Value[0].IP == "203.0.113.255" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"message": "The value is invalid."}`))
+				return
+			}
+			encoded, _ := json.Marshal(record)
+			writes = append(writes, r.Method+" "+string(encoded))
+			_, _ = w.Write([]byte(`{"data": {}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	provider, err := newArvanCloudProvider("user", gjson.Parse(`{"api-key": "1234-abcd", "api-url": "`+api.URL+`/cdn/4.0"}`))
+	if err != nil {
+		t.Fatalf("newArvanCloudProvider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+	result, err := provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		`PUT {"type":"a","name":"home","value":[{"ip":"203.0.113.7"}],"ttl":600,"cloud":true}`,
+		`POST {"type":"aaaa","name":"home","value":[{"ip":"2001:db8::1"}],"ttl":120,"cloud":false}`,
+	}
+	if strings.Join(writes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected record writes:\n%s", strings.Join(writes, "\n"))
+	}
+
+	testCases := map[string]ResultCode{
+		"wrong-key":     ResultBadAuth,
+		"203.0.113.255": ResultServerError,
+		"198.51.100.1":  ResultNoChange,
+	}
+	for testCase, expected := range testCases {
+		key, ip := "1234-abcd", testCase
+		if testCase == "wrong-key" {
+			key, ip = "Apikey wrong", "203.0.113.7"
+		}
+		provider, _ := newArvanCloudProvider("user", gjson.Parse(`{"api-key": "`+key+`", "domain": "example.com", "api-url": "`+api.URL+`/cdn/4.0"}`))
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP(ip)}})
+		if err != nil || result.Code != expected {
+			t.Errorf("%s expected %s but got %v, %v", testCase, expected, result, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerCloudflare = "cloudflare"
+
+	defaultCloudflareAPI = "https://api.cloudflare.com/client/v4"
+	// zone IDs are looked up again after a day, in case the zone moved
+	cloudflareZoneCacheTTL = 24 * time.Hour
+)
+
+// Cloudflare error codes meaning the token is invalid or lacks permissions
+var cloudflareAuthErrors = map[int]bool{9103: true, 9106: true, 9109: true, 10000: true}
+
+// cloudflareProvider updates the A and AAAA records of a host through the
+// Cloudflare v4 API with a scoped API token (Zone:Read and DNS:Edit). The TTL
+// and proxied flag of existing records are kept unless set in the entry.
+type cloudflareProvider struct {
+	api     string
+	token   string
+	zone    string
+	zoneID  string
+	ttl     int64
+	proxied *bool
+	limit   *providerRateLimit
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content"`
+	TTL     int64  `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+}
+
+func newCloudflareProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &cloudflareProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token:  entry.Get("api-token").String(),
+		zone:   strings.TrimSuffix(strings.ToLower(entry.Get("zone").String()), "."),
+		zoneID: entry.Get("zone-id").String(),
+		ttl:    entry.Get("ttl").Int(),
+		limit:  &providerRateLimit{name: "Cloudflare"},
+	}
+	if p.api == "" {
+		p.api = defaultCloudflareAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("cloudflare provider of %s needs an api-token", username)
+	}
+	if proxied := entry.Get("proxied"); proxied.Exists() {
+		value := proxied.Bool()
+		p.proxied = &value
+	}
+	if p.ttl < 0 || (p.ttl > 1 && p.ttl < 30) {
+		return nil, fmt.Errorf("ttl of %s must be 1 (automatic) or at least 30", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *cloudflareProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zoneID, err := p.zoneIDFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, zoneID, req.Host, record.Type, record.IP, true)
+		if err != nil {
+			var apiErr *providerAPIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				// the cached zone may be gone
+				getState().Delete("cloudflare/zone/" + req.Host)
+			}
+			return resultFromError(err)
+		}
+		if changed {
+			code = ResultGood
+			lines = append(lines, fmt.Sprintf("%s %s %s updated", record.Type, req.Host, record.IP))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %s %s unchanged", record.Type, req.Host, record.IP))
+		}
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// zoneIDFor finds the zone of host, trying its parent domains from the longest
+func (p *cloudflareProvider) zoneIDFor(ctx context.Context, host string) (string, error) {
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+	key := "cloudflare/zone/" + host
+	var zoneID string
+	if getState().Get(key, &zoneID) {
+		return zoneID, nil
+	}
+	candidates := zoneCandidates(host)
+	if p.zone != "" {
+		candidates = []string{p.zone}
+	}
+	for _, name := range candidates {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/zones", url.Values{"name": {name}}, nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			getState().Set(key, zones[0].ID, cloudflareZoneCacheTTL)
+			return zones[0].ID, nil
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Cloudflare zone found for " + host}
+}
+
+// upsert sets the record of host to ip, creating it if needed, and reports
+// whether anything changed. A cached record ID that no longer exists is
+// dropped and the record looked up again once.
+func (p *cloudflareProvider) upsert(ctx context.Context, zoneID, host, recordType, ip string, useCache bool) (bool, error) {
+	key := fmt.Sprintf("cloudflare/record/%s/%s/%s", zoneID, host, recordType)
+	var recordID string
+	if !useCache || !getState().Get(key, &recordID) {
+		var records []cloudflareRecord
+		query := url.Values{"type": {recordType}, "name": {host}}
+		if err := p.do(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records", query, nil, &records); err != nil {
+			return false, err
+		}
+		if len(records) > 0 {
+			current := records[0]
+			recordID = current.ID
+			getState().Set(key, recordID, 0)
+			if current.Content == ip && (p.ttl == 0 || current.TTL == p.ttl) &&
+				(p.proxied == nil || (current.Proxied != nil && *current.Proxied == *p.proxied)) {
+				return false, nil
+			}
+		}
+	}
+
+	change := cloudflareRecord{Content: ip, TTL: p.ttl, Proxied: p.proxied}
+	if recordID != "" {
+		err := p.do(ctx, http.MethodPatch, "/zones/"+zoneID+"/dns_records/"+recordID, nil, change, nil)
+		var apiErr *providerAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && useCache {
+			getState().Delete(key)
+			return p.upsert(ctx, zoneID, host, recordType, ip, false)
+		}
+		return err == nil, err
+	}
+
+	change.Type, change.Name = recordType, host
+	if change.TTL == 0 {
+		change.TTL = 1
+	}
+	if change.Proxied == nil {
+		proxied := false
+		change.Proxied = &proxied
+	}
+	var created cloudflareRecord
+	if err := p.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", nil, change, &created); err != nil {
+		return false, err
+	}
+	getState().Set(key, created.ID, 0)
+	return true, nil
+}
+
+// do calls the API and decodes the result of a successful answer into out
+func (p *cloudflareProvider) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	explain := func(apiErr *providerAPIError, data []byte) { explainCloudflareError(method, apiErr, data) }
+	call := jsonCall{provider: "Cloudflare", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {"Bearer " + p.token}}}
+	data, err := doJSONCall(ctx, p.limit, call, explain)
+	if err != nil {
+		return err
+	}
+	var answer cloudflareResponse
+	if err := json.Unmarshal(data, &answer); err != nil || !answer.Success {
+		// Cloudflare also refuses calls with a 200 answer
+		apiErr := &providerAPIError{Code: ResultServerError, StatusCode: http.StatusOK, Message: string(data)}
+		explain(apiErr, data)
+		return apiErr
+	}
+	if out != nil {
+		return json.Unmarshal(answer.Result, out)
+	}
+	return nil
+}
+
+// explainCloudflareError reads the errors envelope of a refused call; method
+// tells a missing record from a missing zone
+func explainCloudflareError(method string, apiErr *providerAPIError, data []byte) {
+	var answer cloudflareResponse
+	_ = json.Unmarshal(data, &answer)
+	var messages []string
+	for _, e := range answer.Errors {
+		if cloudflareAuthErrors[e.Code] {
+			apiErr.Code = ResultBadAuth
+		}
+		messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+	}
+	if len(messages) > 0 {
+		apiErr.Message = strings.Join(messages, "\n")
+	}
+	if apiErr.Code == ResultNoHost && method != http.MethodGet {
+		// a missing record, not a missing zone
+		apiErr.Code = ResultServerError
+	}
+}
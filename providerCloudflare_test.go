@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// fakeCloudflare serves the zone and DNS record endpoints of the v4 API for one zone
+type fakeCloudflare struct {
+	server  *httptest.Server
+	records map[string]map[string]interface{}
+	calls   []string
+}
+
+func newFakeCloudflare(t *testing.T) *fakeCloudflare {
+	f := &fakeCloudflare{records: map[string]map[string]interface{}{
+		"rec-a": {"id": "rec-a", "type": "A", "name": "home.example.com", "content": "198.51.100.1", "ttl": 120, "proxied": true},
+	}}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+		answer := func(status int, result interface{}) {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": status == http.StatusOK, "errors": []interface{}{}, "result": result})
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`))
+			return
+		}
+		switch {
+		case r.URL.Path == "/client/v4/zones":
+			zones := []interface{}{}
+			if r.URL.Query().Get("name") == "example.com" {
+				zones = append(zones, map[string]string{"id": "zone-1", "name": "example.com"})
+			}
+			answer(http.StatusOK, zones)
+		case r.URL.Path == "/client/v4/zones/zone-1/dns_records" && r.Method == http.MethodGet:
+			found := []interface{}{}
+			for _, record := range f.records {
+				if record["type"] == r.URL.Query().Get("type") && record["name"] == r.URL.Query().Get("name") {
+					found = append(found, record)
+				}
+			}
+			answer(http.StatusOK, found)
+		case r.URL.Path == "/client/v4/zones/zone-1/dns_records" && r.Method == http.MethodPost:
+			record := map[string]interface{}{}
+			_ = json.NewDecoder(r.Body).Decode(&record)
+			record["id"] = "rec-" + strings.ToLower(record["type"].(string))
+			f.records[record["id"].(string)] = record
+			answer(http.StatusOK, record)
+		case strings.HasPrefix(r.URL.Path, "/client/v4/zones/zone-1/dns_records/") && r.Method == http.MethodPatch:
+			record, ok := f.records[strings.TrimPrefix(r.URL.Path, "/client/v4/zones/zone-1/dns_records/")]
+			if !ok {
+				answer(http.StatusNotFound, nil)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &record)
+			answer(http.StatusOK, record)
+		default:
+			answer(http.StatusNotFound, nil)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	useMemoryState(t)
+	return f
+}
+
+func TestCloudflareProvider(t *testing.T) {
+	fake := newFakeCloudflare(t)
+	provider, err := newCloudflareProvider("user", gjson.Parse(`{"api-token": "t0ken", "api-url": "`+fake.server.URL+`/client/v4"}`))
+	if err != nil {
+		t.Fatalf("newCloudflareProvider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+
+	result, err := provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	if a := fake.records["rec-a"]; a["content"] != "203.0.113.7" || a["ttl"] != 120 || a["proxied"] != true {
+		t.Errorf("A record was not updated in place: %v", a)
+	}
+	if aaaa := fake.records["rec-aaaa"]; aaaa == nil || aaaa["content"] != "2001:db8::1" || aaaa["proxied"] != false {
+		t.Errorf("AAAA record was not created: %v", aaaa)
+	}
+
+	// zone and record IDs come from the state store now
+	fake.calls = nil
+	if _, err := provider.Update(localProviderContext(), update); err != nil {
+		t.Fatalf("second update failed: %v", err)
+	}
+	if strings.Join(fake.calls, ",") != "PATCH /client/v4/zones/zone-1/dns_records/rec-a,PATCH /client/v4/zones/zone-1/dns_records/rec-aaaa" {
+		t.Errorf("cached IDs were not used: %v", fake.calls)
+	}
+
+	// a record deleted behind our back is looked up and created again
+	delete(fake.records, "rec-aaaa")
+	result, err = provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood || fake.records["rec-aaaa"] == nil {
+		t.Errorf("stale record ID was not recovered: %v, %v", result, err)
+	}
+}
+
+func TestCloudflareProviderErrors(t *testing.T) {
+	fake := newFakeCloudflare(t)
+
+	testCases := map[string]ResultCode{
+		`{"api-token": "wrong"}`:                                   ResultBadAuth,
+		`{"api-token": "t0ken", "zone": "example.org"}`:            ResultNoHost,
+		`{"api-token": "t0ken", "ttl": 300, "proxied": true}`:      ResultGood,
+		`{"api-token": "t0ken", "zone-id": "zone-1", "ttl": 3600}`: ResultGood,
+	}
+
+	for entry, expected := range testCases {
+		state = newStateStore("")
+		provider, err := newCloudflareProvider("user", gjson.Parse(strings.Replace(entry, "{", `{"api-url": "`+fake.server.URL+`/client/v4", `, 1)))
+		if err != nil {
+			t.Fatalf("newCloudflareProvider(%s) failed: %v", entry, err)
+		}
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != expected {
+			t.Errorf("entry %s expected %s but got %v, %v", entry, expected, result, err)
+		}
+	}
+}
+
+func TestCloudflareProviderRateLimit(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 971, "message": "Please wait and consider throttling your request speed"}]}`))
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	provider := newTestProvider(t, newCloudflareProvider, `{"api-token": "t0ken", "zone-id": "zone-1", "api-url": "`+api.URL+`/client/v4"}`)
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}}
+	for i := 0; i < 2; i++ {
+		result, err := provider.Update(localProviderContext(), update)
+		if err != nil || result.Code != ResultServerError || result.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("update %d expected a throttled %s but got %v, %v", i, ResultServerError, result, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the throttled API to be called once but got %d calls", calls)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerDeSEC = "desec"
+
+	defaultDeSECAPI = "https://desec.io/api/v1"
+	// deSEC refuses TTLs under the minimum_ttl of the domain, 3600 unless lowered on request
+	defaultDeSECMinimumTTL = 3600
+	// throttled calls are retried while the wait is at most desecMaxRetryWait
+	desecMaxRetries   = 3
+	desecMaxRetryWait = 30 * time.Second
+)
+
+// deSECProvider replaces the A and AAAA RRsets of a host in deSEC. deSEC
+// throttles API calls strictly: a throttled call is retried after the
+// Retry-After of the answer when it is short, otherwise the provider stops
+// calling until then.
+type deSECProvider struct {
+	api    string
+	token  string
+	domain string
+	ttl    int64
+	limit  *providerRateLimit
+}
+
+type deSECRRset struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+// deSECDomain is the name and minimum TTL of the domain of a host
+type deSECDomain struct {
+	Name       string `json:"name"`
+	MinimumTTL int64  `json:"minimum_ttl"`
+}
+
+func newDeSECProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &deSECProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token:  entry.Get("token").String(),
+		domain: strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:    entry.Get("ttl").Int(),
+		limit:  &providerRateLimit{name: "deSEC"},
+	}
+	if p.api == "" {
+		p.api = defaultDeSECAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("desec provider of %s needs a token", username)
+	}
+	if p.ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl of %s", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *deSECProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	subname := relativeRecordName(req.Host, domain.Name)
+	pathName := subname
+	if subname == "@" {
+		subname = ""
+	}
+
+	var changes []deSECRRset
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		rrset := deSECRRset{Subname: subname, Type: record.Type, TTL: p.ttl, Records: []string{record.IP}}
+		var current deSECRRset
+		err := p.do(ctx, http.MethodGet, "/domains/"+url.PathEscape(domain.Name)+"/rrsets/"+url.PathEscape(pathName)+"/"+record.Type+"/", nil, &current)
+		var apiErr *providerAPIError
+		switch {
+		case err == nil:
+			if rrset.TTL == 0 {
+				rrset.TTL = current.TTL
+			}
+		case !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound:
+			return resultFromError(err)
+		}
+		if rrset.TTL < domain.MinimumTTL {
+			rrset.TTL = domain.MinimumTTL
+		}
+		if err == nil && current.TTL == rrset.TTL && len(current.Records) == 1 && current.Records[0] == record.IP {
+			lines = append(lines, fmt.Sprintf("%s %s %s unchanged", record.Type, req.Host, record.IP))
+			continue
+		}
+		changes = append(changes, rrset)
+		lines = append(lines, fmt.Sprintf("%s %s %s updated", record.Type, req.Host, record.IP))
+	}
+	code := ResultNoChange
+	if len(changes) > 0 {
+		// a bulk PUT creates or replaces all RRsets in one call
+		if err := p.do(ctx, http.MethodPut, "/domains/"+url.PathEscape(domain.Name)+"/rrsets/", changes, nil); err != nil {
+			return resultFromError(err)
+		}
+		code = ResultGood
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// domainFor finds the domain of host, the configured one or the one of the account owning host
+func (p *deSECProvider) domainFor(ctx context.Context, host string) (deSECDomain, error) {
+	key := "desec/domain/" + host
+	var domain deSECDomain
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	var domains []deSECDomain
+	if p.domain != "" {
+		var one deSECDomain
+		if err := p.do(ctx, http.MethodGet, "/domains/"+url.PathEscape(p.domain)+"/", nil, &one); err != nil {
+			return domain, err
+		}
+		domains = append(domains, one)
+	} else {
+		query := url.Values{"owns_qname": {strings.TrimSuffix(strings.ToLower(host), ".")}}
+		if err := p.do(ctx, http.MethodGet, "/domains/?"+query.Encode(), nil, &domains); err != nil {
+			return domain, err
+		}
+	}
+	if len(domains) == 0 {
+		return domain, &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no deSEC domain found for " + host}
+	}
+	domain = domains[0]
+	if domain.MinimumTTL == 0 {
+		domain.MinimumTTL = defaultDeSECMinimumTTL
+	}
+	getState().Set(key, domain, 24*time.Hour)
+	return domain, nil
+}
+
+// do calls the API, retrying throttled calls, and decodes the JSON answer into out
+func (p *deSECProvider) do(ctx context.Context, method, path string, in, out interface{}) error {
+	call := jsonCall{provider: "deSEC", method: method, url: p.api + path, in: in,
+		header: http.Header{"Authorization": {"Token " + p.token}}}
+	for attempt := 1; ; attempt++ {
+		data, err := doJSONCall(ctx, p.limit, call, func(apiErr *providerAPIError, data []byte) {
+			if detail := gjson.GetBytes(data, "detail").String(); detail != "" {
+				apiErr.Message = detail
+			}
+			if apiErr.StatusCode == http.StatusBadRequest {
+				apiErr.Code = ResultServerError
+			}
+		})
+		var apiErr *providerAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests && attempt < desecMaxRetries {
+			if wait := p.limit.remaining(); wait <= desecMaxRetryWait {
+				getLogger().Infof("deSEC throttled the call, retrying in %s", wait)
+				select {
+				case <-time.After(wait):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("invalid deSEC answer: %v", err)
+			}
+		}
+		return nil
+	}
+}
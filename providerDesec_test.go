@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeSECProvider(t *testing.T) {
+	var writes []string
+	throttle := map[string]int{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token desec_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail": "Invalid token."}`))
+			return
+		}
+		// the first GET of an RRset is throttled with the wait in the host name
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/rrsets/") && throttle[r.URL.Path] == 0 {
+			throttle[r.URL.Path]++
+			if strings.Contains(r.URL.Path, "slow") {
+				w.Header().Set("Retry-After", "3600")
+			} else {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"detail": "Request was throttled. Expected available in 1 second."}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/api/v1/domains/" && r.URL.Query().Get("owns_qname") == "home.example.com":
+			_, _ = w.Write([]byte(`[{"name": "example.com", "minimum_ttl": 3600}]`))
+		case r.URL.Path == "/api/v1/domains/":
+			_, _ = w.Write([]byte(`[]`))
+		case r.URL.Path == "/api/v1/domains/dedyn.example/":
+			_, _ = w.Write([]byte(`{"name": "dedyn.example", "minimum_ttl": 60}`))
+		case r.URL.Path == "/api/v1/domains/example.com/rrsets/home/A/":
+			_, _ = w.Write([]byte(`{"subname": "home", "type": "A", "ttl": 7200, "records": ["198.51.100.1"]}`))
+		case r.URL.Path == "/api/v1/domains/dedyn.example/rrsets/@/A/":
+			_, _ = w.Write([]byte(`{"subname": "", "type": "A", "ttl": 60, "records": ["203.0.113.7"]}`))
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/rrsets/"):
+			writes = append(writes, r.URL.Path+" "+string(body))
+			_, _ = w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"detail": "Not found."}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(entry string) Provider {
+		return newTestProvider(t, newDeSECProvider, `{"api-url": "`+api.URL+`/api/v1", `+entry+`}`)
+	}
+
+	result, err := fromEntry(`"token": "desec_token", "ttl": 60`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	// the ttl is raised to the minimum of the domain
+	expected := `/api/v1/domains/example.com/rrsets/ [{"subname":"home","type":"A","ttl":3600,"records":["203.0.113.7"]},` +
+		`{"subname":"home","type":"AAAA","ttl":3600,"records":["2001:db8::1"]}]`
+	if len(writes) != 1 || writes[0] != expected {
+		t.Errorf("unexpected RRset writes:\n%s", strings.Join(writes, "\n"))
+	}
+
+	testCases := map[string]struct {
+		entry  string
+		host   string
+		code   ResultCode
+		status int
+	}{
+		"apex unchanged": {`"token": "desec_token", "domain": "dedyn.example"`, "dedyn.example", ResultNoChange, http.StatusOK},
+		"throttled":      {`"token": "desec_token", "domain": "dedyn.example"`, "slow.dedyn.example", ResultServerError, http.StatusTooManyRequests},
+		"bad token":      {`"token": "wrong"`, "home.example.com", ResultBadAuth, http.StatusUnauthorized},
+		"no domain":      {`"token": "desec_token"`, "home.example.org", ResultNoHost, http.StatusNotFound},
+	}
+	for name, testCase := range testCases {
+		getState().DeletePrefix("desec/")
+		result, err := fromEntry(testCase.entry).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code || result.StatusCode != testCase.status {
+			t.Errorf("%s expected %s (%d) but got %v, %v", name, testCase.code, testCase.status, result, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerDigitalOcean = "digitalocean"
+
+	defaultDigitalOceanAPI = "https://api.digitalocean.com/v2"
+	defaultDigitalOceanTTL = 1800
+)
+
+// digitalOceanProvider updates the A and AAAA records of a host in
+// DigitalOcean DNS. The domain and the record IDs are cached in the state
+// store, so an update of a known record is a single PATCH.
+type digitalOceanProvider struct {
+	api    string
+	token  string
+	domain string
+	ttl    int64
+	limit  *providerRateLimit
+}
+
+type digitalOceanRecord struct {
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+	Data string `json:"data"`
+	TTL  int64  `json:"ttl,omitempty"`
+}
+
+func newDigitalOceanProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &digitalOceanProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token:  entry.Get("token").String(),
+		domain: strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:    entry.Get("ttl").Int(),
+		limit:  &providerRateLimit{name: "DigitalOcean"},
+	}
+	if p.api == "" {
+		p.api = defaultDigitalOceanAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("digitalocean provider of %s needs a token", username)
+	}
+	if p.ttl != 0 && p.ttl < 30 {
+		return nil, fmt.Errorf("invalid ttl of %s, it must be at least 30", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *digitalOceanProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, domain, req.Host, record, true)
+		if err != nil {
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// domainFor returns the configured domain, or the longest domain of the account host is in
+func (p *digitalOceanProvider) domainFor(ctx context.Context, host string) (string, error) {
+	if p.domain != "" {
+		return p.domain, nil
+	}
+	key := "digitalocean/domain/" + host
+	var domain string
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	for _, name := range zoneCandidates(host) {
+		_, err := p.do(ctx, http.MethodGet, "/domains/"+url.PathEscape(name), nil, nil)
+		if err == nil {
+			getState().Set(key, name, 24*time.Hour)
+			return name, nil
+		}
+		var apiErr *providerAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			return "", err
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no DigitalOcean domain found for " + host}
+}
+
+// upsert sets the record of host to the address, creating it if needed, and
+// reports whether anything changed. A cached record ID that no longer
+// exists is dropped and the record looked up again once.
+func (p *digitalOceanProvider) upsert(ctx context.Context, domain, host string, address addressRecord, useCache bool) (bool, error) {
+	key := fmt.Sprintf("digitalocean/record/%s/%s/%s", domain, host, address.Type)
+	recordsPath := "/domains/" + url.PathEscape(domain) + "/records"
+	var recordID int64
+	if !useCache || !getState().Get(key, &recordID) {
+		current, err := p.find(ctx, recordsPath, host, address.Type)
+		if err != nil {
+			return false, err
+		}
+		if current != nil {
+			recordID = current.ID
+			getState().Set(key, recordID, 0)
+			if current.Data == address.IP && (p.ttl == 0 || current.TTL == p.ttl) {
+				return false, nil
+			}
+		}
+	}
+
+	if recordID != 0 {
+		_, err := p.do(ctx, http.MethodPatch, fmt.Sprintf("%s/%d", recordsPath, recordID), nil, digitalOceanRecord{Data: address.IP, TTL: p.ttl})
+		var apiErr *providerAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && useCache {
+			getState().Delete(key)
+			return p.upsert(ctx, domain, host, address, false)
+		}
+		return err == nil, err
+	}
+
+	record := digitalOceanRecord{Type: address.Type, Name: relativeRecordName(host, domain), Data: address.IP, TTL: p.ttl}
+	if record.TTL == 0 {
+		record.TTL = defaultDigitalOceanTTL
+	}
+	data, err := p.do(ctx, http.MethodPost, recordsPath, nil, record)
+	if err != nil {
+		return false, err
+	}
+	getState().Set(key, gjson.GetBytes(data, "domain_record.id").Int(), 0)
+	return true, nil
+}
+
+// find returns the record of host and type, going through the pages of the list
+func (p *digitalOceanProvider) find(ctx context.Context, recordsPath, host, recordType string) (*digitalOceanRecord, error) {
+	query := url.Values{"type": {recordType}, "name": {strings.TrimSuffix(strings.ToLower(host), ".")}, "per_page": {"200"}}
+	for page := 1; ; page++ {
+		query.Set("page", fmt.Sprint(page))
+		data, err := p.do(ctx, http.MethodGet, recordsPath, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var answer struct {
+			Records []digitalOceanRecord `json:"domain_records"`
+		}
+		if err := json.Unmarshal(data, &answer); err != nil {
+			return nil, fmt.Errorf("invalid DigitalOcean answer: %v", err)
+		}
+		for i := range answer.Records {
+			if answer.Records[i].Type == recordType {
+				return &answer.Records[i], nil
+			}
+		}
+		if gjson.GetBytes(data, "links.pages.next").String() == "" {
+			return nil, nil
+		}
+	}
+}
+
+// do calls the API; refusals carry the id and message of the answer
+func (p *digitalOceanProvider) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	call := jsonCall{provider: "DigitalOcean", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {"Bearer " + p.token}}}
+	return doJSONCall(ctx, p.limit, call, func(apiErr *providerAPIError, data []byte) {
+		if message := gjson.GetBytes(data, "message").String(); message != "" {
+			apiErr.Message = gjson.GetBytes(data, "id").String() + ": " + message
+		}
+	})
+}
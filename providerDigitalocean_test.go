@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestDigitalOceanProvider(t *testing.T) {
+	var writes []string
+	limited := false
+	limitedCalls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer do_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"id": "unauthorized", "message": "Unable to authenticate you"}`))
+			return
+		}
+		if limited {
+			limitedCalls++
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"id": "too_many_requests", "message": "API Rate limit exceeded."}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/domains/example.com":
+			_, _ = w.Write([]byte(`{"domain": {"name": "example.com", "ttl": 1800}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/domains/example.com/records":
+			// the A record is on the second page
+			if query.Get("type") == "A" && query.Get("page") == "1" {
+				_, _ = w.Write([]byte(`{"domain_records": [{"id": 1, "type": "CNAME", "name": "home", "data": "x"}], "links": {"pages": {"next": "https://api/next"}}}`))
+			} else if query.Get("type") == "A" {
+				_, _ = w.Write([]byte(`{"domain_records": [{"id": 2, "type": "A", "name": "home", "data": "198.51.100.1", "ttl": 60}], "links": {}}`))
+			} else {
+				_, _ = w.Write([]byte(`{"domain_records": [], "links": {}}`))
+			}
+		case r.Method == http.MethodPatch && r.URL.Path == "/v2/domains/example.com/records/2":
+			writes = append(writes, "PATCH 2 "+string(body))
+			_, _ = w.Write([]byte(`{"domain_record": {"id": 2}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v2/domains/example.com/records":
+			writes = append(writes, "POST "+string(body))
+			_, _ = w.Write([]byte(`{"domain_record": {"id": 3}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"id": "not_found", "message": "The resource you were accessing could not be found."}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	provider, err := newDigitalOceanProvider("user", gjson.Parse(`{"token": "do_token", "api-url": "`+api.URL+`/v2"}`))
+	if err != nil {
+		t.Fatalf("newDigitalOceanProvider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+	if result, err := provider.Update(localProviderContext(), update); err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	// the second update uses the cached IDs; the fake does not keep the
+	// created AAAA record, so its stale ID is dropped and the record created again
+	if result, err := provider.Update(localProviderContext(), update); err != nil || result.Code != ResultGood {
+		t.Fatalf("second update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		`PATCH 2 {"data":"203.0.113.7"}`,
+		`POST {"type":"AAAA","name":"home","data":"2001:db8::1","ttl":1800}`,
+		`PATCH 2 {"data":"203.0.113.7"}`,
+		`POST {"type":"AAAA","name":"home","data":"2001:db8::1","ttl":1800}`,
+	}
+	if strings.Join(writes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected record writes:\n%s", strings.Join(writes, "\n"))
+	}
+
+	var cached int64
+	if !getState().Get("digitalocean/record/example.com/home.example.com/AAAA", &cached) || cached != 3 {
+		t.Errorf("created record ID not cached, got %d", cached)
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		code  ResultCode
+	}{
+		"bad token": {`"token": "wrong"`, "home.example.com", ResultBadAuth},
+		"no domain": {`"token": "do_token"`, "home.example.org", ResultNoHost},
+	}
+	for name, testCase := range testCases {
+		provider, _ := newDigitalOceanProvider("user", gjson.Parse(`{"api-url": "`+api.URL+`/v2", `+testCase.entry+`}`))
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+
+	// after a 429 the API is not called until Retry-After passed
+	limited = true
+	provider, _ = newDigitalOceanProvider("user", gjson.Parse(`{"token": "do_token", "domain": "example.com", "api-url": "`+api.URL+`/v2"}`))
+	for i := 0; i < 2; i++ {
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "new.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != ResultServerError || result.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("rate limited update expected %s but got %v, %v", ResultServerError, result, err)
+		}
+	}
+	if limitedCalls != 1 {
+		t.Errorf("expected one call while rate limited but got %d", limitedCalls)
+	}
+}
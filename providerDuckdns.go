@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerDuckDNS = "duckdns"
+
+	defaultDuckDNSServer = "https://www.duckdns.org/update"
+	duckDNSSuffix        = ".duckdns.org"
+)
+
+// duckDNSProvider updates DuckDNS subdomains. The host and the extra
+// "domains" of the entry are updated in one call. Besides the addresses it
+// can clear the records (clear=true) and set the TXT record (txt=...) of the
+// subdomains, both also taken from the update request parameters.
+type duckDNSProvider struct {
+	server  *url.URL
+	token   string
+	domains []string
+}
+
+func newDuckDNSProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &duckDNSProvider{token: entry.Get("token").String()}
+	if p.token == "" {
+		return nil, fmt.Errorf("duckdns provider of %s needs a token", username)
+	}
+	server := entry.Get("server").String()
+	if server == "" {
+		server = defaultDuckDNSServer
+	}
+	var err error
+	if p.server, err = url.Parse(server); err != nil || p.server.Host == "" {
+		return nil, fmt.Errorf("invalid server %q of %s", server, username)
+	}
+	domains := entry.Get("domains")
+	if domains.IsArray() {
+		for _, domain := range domains.Array() {
+			p.domains = append(p.domains, domain.String())
+		}
+	} else if domains.String() != "" {
+		p.domains = strings.Split(domains.String(), ",")
+	}
+	for i, domain := range p.domains {
+		if p.domains[i] = duckDNSSubdomain(domain); p.domains[i] == "" {
+			return nil, fmt.Errorf("invalid duckdns domain %q of %s", domain, username)
+		}
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+// duckDNSSubdomain returns the DuckDNS subdomain of name, which may be the
+// subdomain itself or a host under it, or an empty string for other names
+func duckDNSSubdomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if strings.HasSuffix(name, duckDNSSuffix) {
+		labels := strings.Split(strings.TrimSuffix(name, duckDNSSuffix), ".")
+		return labels[len(labels)-1]
+	}
+	if strings.Contains(name, ".") {
+		return ""
+	}
+	return name
+}
+
+// subdomains lists the subdomain of host followed by the extra domains
+func (p *duckDNSProvider) subdomains(host string) []string {
+	subdomains := []string{duckDNSSubdomain(host)}
+	for _, domain := range p.domains {
+		if domain != subdomains[0] {
+			subdomains = append(subdomains, domain)
+		}
+	}
+	return subdomains
+}
+
+func (p *duckDNSProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	if duckDNSSubdomain(req.Host) == "" {
+		return &UpdateResult{Code: ResultNotFQDN, Body: req.Host + " is not a duckdns subdomain"}, nil
+	}
+	if txt, ok := req.Params["txt"].(string); ok {
+		return p.UpdateTXT(ctx, req.Host, txt)
+	}
+	query := url.Values{"domains": {strings.Join(p.subdomains(req.Host), ",")}}
+	if clearRecords, _ := req.Params["clear"].(string); isTruthy(clearRecords) {
+		query.Set("clear", "true")
+	} else {
+		// an empty ip would make DuckDNS use the address of the proxy
+		if ipv4 := req.IPv4(); ipv4 != "" {
+			query.Set("ip", ipv4)
+		}
+		if ipv6 := req.IPv6(); ipv6 != "" {
+			query.Set("ipv6", ipv6)
+		}
+	}
+	return p.call(ctx, query)
+}
+
+// UpdateTXT sets the TXT record of the DuckDNS subdomains of host, an empty
+// value clears it. DuckDNS has a single TXT record per subdomain.
+func (p *duckDNSProvider) UpdateTXT(ctx context.Context, host, value string) (*UpdateResult, error) {
+	query := url.Values{"domains": {strings.Join(p.subdomains(host), ",")}, "txt": {value}}
+	if value == "" {
+		query.Set("clear", "true")
+	}
+	return p.call(ctx, query)
+}
+
+// call sends a verbose update, its answer is OK or KO followed by the values
+// set and UPDATED or NOCHANGE
+func (p *duckDNSProvider) call(ctx context.Context, query url.Values) (*UpdateResult, error) {
+	query.Set("token", p.token)
+	query.Set("verbose", "true")
+	target := *p.server
+	target.RawQuery = query.Encode()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building duckdns request: %v", err)
+	}
+
+	getLogger().Debugf("Calling duckdns %s", redact(target.String()))
+	resp, body, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling duckdns: %v", err)
+	}
+	result := &UpdateResult{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	lines := strings.Fields(result.Body)
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		result.Code = httpStatusResultCode(resp.StatusCode)
+	case len(lines) > 0 && lines[0] == "KO":
+		// DuckDNS does not tell a wrong token from a subdomain of another account
+		result.Code = ResultBadAuth
+	case len(lines) > 0 && lines[0] == "OK":
+		result.Code = ResultGood
+		if lines[len(lines)-1] == "NOCHANGE" {
+			result.Code = ResultNoChange
+		}
+	default:
+		result.Code = ResultServerError
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestDuckDNSSubdomain(t *testing.T) {
+	testCases := map[string]string{
+		"home":                   "home",
+		"home.duckdns.org":       "home",
+		"Home.DuckDNS.org.":      "home",
+		"www.home.duckdns.org":   "home",
+		"home.example.com":       "",
+		" cam.duckdns.org ":      "cam",
+		"_acme.home.duckdns.org": "home",
+	}
+	for name, expected := range testCases {
+		if subdomain := duckDNSSubdomain(name); subdomain != expected {
+			t.Errorf("%q expected %q but got %q", name, expected, subdomain)
+		}
+	}
+}
+
+func TestDuckDNSProvider(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		queries = append(queries, r.URL.RawQuery)
+		switch {
+		case query.Get("token") != "token1":
+			_, _ = w.Write([]byte("KO"))
+		case query.Get("ip") == "203.0.113.1":
+			_, _ = w.Write([]byte("OK\n203.0.113.1\n\nNOCHANGE"))
+		case query.Get("ip") == "203.0.113.2":
+			_, _ = w.Write([]byte("maintenance"))
+		default:
+			_, _ = w.Write([]byte("OK\n203.0.113.7\n2001:db8::1\nUPDATED"))
+		}
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		token  string
+		ips    []string
+		params map[string]interface{}
+		code   ResultCode
+		query  string
+	}{
+		"update": {
+			token: "token1", ips: []string{"203.0.113.7", "2001:db8::1"}, code: ResultGood,
+			query: "domains=home%2Ccam&ip=203.0.113.7&ipv6=2001%3Adb8%3A%3A1&token=token1&verbose=true",
+		},
+		"nochg": {
+			token: "token1", ips: []string{"203.0.113.1"}, code: ResultNoChange,
+			query: "domains=home%2Ccam&ip=203.0.113.1&token=token1&verbose=true",
+		},
+		"clear": {
+			token: "token1", ips: []string{"203.0.113.7"}, params: map[string]interface{}{"clear": "true"}, code: ResultGood,
+			query: "clear=true&domains=home%2Ccam&token=token1&verbose=true",
+		},
+		"txt": {
+			token: "token1", ips: []string{"203.0.113.7"}, params: map[string]interface{}{"txt": "challenge"}, code: ResultGood,
+			query: "domains=home%2Ccam&token=token1&txt=challenge&verbose=true",
+		},
+		"txt clear": {
+			token: "token1", ips: []string{"203.0.113.7"}, params: map[string]interface{}{"txt": ""}, code: ResultGood,
+			query: "clear=true&domains=home%2Ccam&token=token1&txt=&verbose=true",
+		},
+		"ko": {
+			token: "wrong", ips: []string{"203.0.113.7"}, code: ResultBadAuth,
+			query: "domains=home%2Ccam&ip=203.0.113.7&token=wrong&verbose=true",
+		},
+		"unknown answer": {
+			token: "token1", ips: []string{"203.0.113.2"}, code: ResultServerError,
+			query: "domains=home%2Ccam&ip=203.0.113.2&token=token1&verbose=true",
+		},
+	}
+	for name, testCase := range testCases {
+		provider, err := newDuckDNSProvider("user", gjson.Parse(`{"token": "`+testCase.token+`", "domains": ["cam.duckdns.org", "home"], "server": "`+server.URL+`/update"}`))
+		if err != nil {
+			t.Fatalf("newDuckDNSProvider failed: %v", err)
+		}
+		update := &UpdateRequest{Host: "home.duckdns.org", Params: testCase.params}
+		for _, ip := range testCase.ips {
+			update.IPs = append(update.IPs, net.ParseIP(ip))
+		}
+		queries = nil
+		result, err := provider.Update(localProviderContext(), update)
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+		if len(queries) != 1 || queries[0] != testCase.query {
+			t.Errorf("%s expected query %s but got %v", name, testCase.query, queries)
+		}
+	}
+
+	if _, ok := interface{}(&duckDNSProvider{}).(txtProvider); !ok {
+		t.Errorf("duckdns provider does not set TXT records")
+	}
+}
+
+func TestRequestedRecordChange(t *testing.T) {
+	testCases := map[string]struct {
+		request  *http.Request
+		provider Provider
+		expected bool
+	}{
+		"address only":       {httptest.NewRequest(http.MethodGet, "/update?ip=203.0.113.7", nil), &duckDNSProvider{}, false},
+		"txt in the query":   {httptest.NewRequest(http.MethodGet, "/update?txt=token", nil), &duckDNSProvider{}, true},
+		"clear in the query": {httptest.NewRequest(http.MethodGet, "/update?clear=true", nil), &duckDNSProvider{}, true},
+		"clear set to no":    {httptest.NewRequest(http.MethodGet, "/update?clear=no", nil), &duckDNSProvider{}, false},
+		"txt in the form":    {postForm("txt=token"), &duckDNSProvider{}, true},
+		"clear in the form":  {postForm("clear=yes"), &duckDNSProvider{}, true},
+		"no txt support":     {httptest.NewRequest(http.MethodGet, "/update?txt=token", nil), &multiProvider{}, false},
+	}
+	for name, testCase := range testCases {
+		params, err := GetParamsAsMap(testCase.request)
+		if err != nil {
+			t.Fatalf("%s: GetParamsAsMap failed: %v", name, err)
+		}
+		if changed := requestedRecordChange(*params, testCase.provider); changed != testCase.expected {
+			t.Errorf("%s expected %v but got %v", name, testCase.expected, changed)
+		}
+	}
+}
+
+func postForm(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
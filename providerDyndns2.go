@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerDyndns2 = "dyndns2"
+	providerNoIP    = "noip"
+	providerDyn     = "dyn"
+	providerDynu    = "dynu"
+	providerHE      = "he"
+	providerFreeDNS = "freedns"
+
+	// dyndns2HaltDuration is how long a service is not called after an
+	// answer that must not be retried
+	dyndns2HaltDuration = time.Hour
+	// dyndns2ServerErrorHaltDuration is the wait the protocol asks for after
+	// a 911 or dnserr answer
+	dyndns2ServerErrorHaltDuration = 30 * time.Minute
+
+	// dyndns2HostParam takes the host unless a preset or entry names another parameter
+	dyndns2HostParam = "hostname"
+)
+
+// dyndns2IPMode is how a service takes the addresses of an update
+type dyndns2IPMode int
+
+const (
+	// all addresses comma separated in myip
+	dyndns2IPJoined dyndns2IPMode = iota
+	// IPv4 in myip and IPv6 in myipv6
+	dyndns2IPSplit
+	// one request per address, in myip
+	dyndns2IPPerRequest
+)
+
+// dyndns2Preset holds what differs between the services speaking dyndns2
+type dyndns2Preset struct {
+	server string
+	// hostParam is the parameter taking the host, hostname if empty
+	hostParam string
+	ipMode    dyndns2IPMode
+	// userFromHost services authenticate with the hostname and a per-host key
+	userFromHost bool
+	// noChange lists answers meaning nochg besides the standard one
+	noChange []string
+}
+
+var dyndns2Presets = map[string]dyndns2Preset{
+	providerDyndns2: {ipMode: dyndns2IPJoined},
+	providerNoIP:    {server: "https://dynupdate.no-ip.com/nic/update", ipMode: dyndns2IPJoined},
+	providerDyn:     {server: "https://members.dyndns.org/v3/update", ipMode: dyndns2IPJoined},
+	providerDynu:    {server: "https://api.dynu.com/nic/update", ipMode: dyndns2IPSplit},
+	providerHE:      {server: "https://dyn.dns.he.net/nic/update", ipMode: dyndns2IPPerRequest, userFromHost: true},
+	providerFreeDNS: {server: "https://freedns.afraid.org/nic/update", ipMode: dyndns2IPPerRequest, noChange: []string{"has not changed"}},
+}
+
+// dyndns2UserAgent follows the "Company Client/Version contact" format No-IP requires
+var dyndns2UserAgent = fmt.Sprintf("%s ddns-proxy/1.0 %s", copyrightParameters["applicationExeName"], copyrightParameters["email"])
+
+// dyndns2Provider updates a host through a dyndns2 compatible service. After
+// an answer the protocol says must not be retried (badauth, abuse, badagent,
+// ...), the provider answers it again without calling the service for
+// dyndns2HaltDuration, as the services block clients that keep sending;
+// after 911 and dnserr it waits dyndns2ServerErrorHaltDuration.
+type dyndns2Provider struct {
+	name      string
+	preset    dyndns2Preset
+	server    *url.URL
+	hostParam string
+	user      string
+	pass      string
+	userAgent string
+
+	mu          sync.Mutex
+	halted      *UpdateResult
+	haltedUntil time.Time
+}
+
+func newDyndns2Provider(name string) providerFactory {
+	return func(username string, entry gjson.Result) (Provider, error) {
+		p := &dyndns2Provider{
+			name:      name,
+			preset:    dyndns2Presets[name],
+			user:      entry.Get("dd-user").String(),
+			pass:      entry.Get("dd-pass").String(),
+			userAgent: entry.Get("user-agent").String(),
+		}
+		p.hostParam = entry.Get("host-param").String()
+		if p.hostParam == "" {
+			p.hostParam = p.preset.hostParam
+		}
+		if p.hostParam == "" {
+			p.hostParam = dyndns2HostParam
+		}
+		server := p.preset.server
+		if s := entry.Get("server").String(); s != "" {
+			server = s
+		}
+		if server == "" {
+			return nil, fmt.Errorf("%s provider of %s needs a server", name, username)
+		}
+		var err error
+		if p.server, err = url.Parse(server); err != nil || p.server.Host == "" {
+			return nil, fmt.Errorf("invalid server %q of %s", server, username)
+		}
+		if p.pass == "" || (p.user == "" && !p.preset.userFromHost) {
+			return nil, fmt.Errorf("%s provider of %s needs dd-user and dd-pass", name, username)
+		}
+		if p.userAgent == "" {
+			p.userAgent = dyndns2UserAgent
+		}
+		registerSecrets(p.pass)
+		return p, nil
+	}
+}
+
+func (p *dyndns2Provider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	p.mu.Lock()
+	halted, until := p.halted, p.haltedUntil
+	p.mu.Unlock()
+	if halted != nil && time.Now().Before(until) {
+		getLogger().Warnf("Not calling %s for %s after %s until %s", p.name, req.Host, halted.Code, until.UTC().Format(time.RFC3339))
+		return halted, nil
+	}
+
+	var queries []url.Values
+	switch p.preset.ipMode {
+	case dyndns2IPJoined:
+		queries = append(queries, url.Values{"myip": {strings.Join(req.IPStrings(), ",")}})
+	case dyndns2IPSplit:
+		query := url.Values{}
+		if ipv4 := req.IPv4(); ipv4 != "" {
+			query.Set("myip", ipv4)
+		}
+		if ipv6 := req.IPv6(); ipv6 != "" {
+			query.Set("myipv6", ipv6)
+		}
+		queries = append(queries, query)
+	case dyndns2IPPerRequest:
+		for _, ip := range req.IPStrings() {
+			queries = append(queries, url.Values{"myip": {ip}})
+		}
+	}
+
+	if len(queries) == 0 {
+		return &UpdateResult{Code: ResultServerError, Body: "no address to send to " + p.name}, nil
+	}
+	var result *UpdateResult
+	for _, query := range queries {
+		query.Set(p.hostParam, req.Host)
+		var err error
+		if result, err = p.call(ctx, req.Host, query); err != nil {
+			return nil, err
+		}
+		if !result.Success() {
+			break
+		}
+	}
+
+	var halt time.Duration
+	switch result.Code {
+	case ResultBadAuth, ResultAbuse, ResultBadAgent, ResultNotFQDN, ResultNoHost:
+		halt = dyndns2HaltDuration
+	case ResultServerError, ResultDNSError:
+		halt = dyndns2ServerErrorHaltDuration
+	}
+	if halt > 0 {
+		p.mu.Lock()
+		p.halted, p.haltedUntil = result, time.Now().Add(halt)
+		p.mu.Unlock()
+	}
+	return result, nil
+}
+
+func (p *dyndns2Provider) call(ctx context.Context, host string, query url.Values) (*UpdateResult, error) {
+	target := *p.server
+	target.RawQuery = query.Encode()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building %s request: %v", p.name, err)
+	}
+	user := p.user
+	if user == "" {
+		user = host
+	}
+	httpReq.SetBasicAuth(user, p.pass)
+	httpReq.Header.Set("User-Agent", p.userAgent)
+
+	getLogger().Debugf("Calling %s %s", p.name, redact(target.String()))
+	resp, body, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %v", p.name, err)
+	}
+	return &UpdateResult{
+		Code:       p.resultCode(resp.StatusCode, string(body)),
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}, nil
+}
+
+// dyndns2Codes maps the first word of a dyndns2 answer to a result code
+var dyndns2Codes = map[string]ResultCode{
+	"good":     ResultGood,
+	"nochg":    ResultNoChange,
+	"badauth":  ResultBadAuth,
+	"!yours":   ResultBadAuth,
+	"notfqdn":  ResultNotFQDN,
+	"nohost":   ResultNoHost,
+	"abuse":    ResultAbuse,
+	"badagent": ResultBadAgent,
+	"dnserr":   ResultDNSError,
+	"911":      ResultServerError,
+}
+
+// resultCode reads the answer of the service. An answer covering several
+// hosts has one line per host; the worst of them is returned.
+func (p *dyndns2Provider) resultCode(status int, body string) ResultCode {
+	for _, pattern := range p.preset.noChange {
+		if strings.Contains(body, pattern) {
+			return ResultNoChange
+		}
+	}
+	var codes []ResultCode
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		code, ok := dyndns2Codes[strings.ToLower(fields[0])]
+		if !ok {
+			// !donator, numhost and unknown answers
+			code = ResultServerError
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		switch {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return ResultBadAuth
+		case status == http.StatusOK:
+			return ResultGood
+		}
+		return ResultServerError
+	}
+	worst := ResultNoChange
+	for _, code := range codes {
+		switch {
+		case code == ResultGood && worst == ResultNoChange:
+			worst = ResultGood
+		case code != ResultGood && code != ResultNoChange:
+			return code
+		}
+	}
+	return worst
+}
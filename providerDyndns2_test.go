@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// fakeDyndns2 answers like a dyndns2 service, with the answer given in the password
+type fakeDyndns2 struct {
+	server   *httptest.Server
+	queries  []string
+	users    []string
+	agents   []string
+	requests int
+}
+
+func newFakeDyndns2(t *testing.T) *fakeDyndns2 {
+	f := &fakeDyndns2{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests++
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("badauth"))
+			return
+		}
+		f.queries = append(f.queries, r.URL.RawQuery)
+		f.users = append(f.users, user)
+		f.agents = append(f.agents, r.UserAgent())
+		_, _ = w.Write([]byte(strings.ReplaceAll(pass, "_", " ")))
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func TestDyndns2ProviderResults(t *testing.T) {
+	fake := newFakeDyndns2(t)
+
+	testCases := map[string]ResultCode{
+		"good_203.0.113.7":                    ResultGood,
+		"nochg_203.0.113.7":                   ResultNoChange,
+		"badauth":                             ResultBadAuth,
+		"abuse":                               ResultAbuse,
+		"badagent":                            ResultBadAgent,
+		"nohost":                              ResultNoHost,
+		"notfqdn":                             ResultNotFQDN,
+		"dnserr":                              ResultDNSError,
+		"911":                                 ResultServerError,
+		"!donator":                            ResultServerError,
+		"nochg_203.0.113.7\ngood_2001:db8::1": ResultGood,
+		"good_203.0.113.7\nnohost":            ResultNoHost,
+	}
+
+	for answer, expected := range testCases {
+		provider, err := newDyndns2Provider(providerNoIP)("user", gjson.Parse(`{
+			"dd-user": "u", "dd-pass": "`+strings.ReplaceAll(answer, "\n", `\n`)+`", "server": "`+fake.server.URL+`/nic/update"
+		}`))
+		if err != nil {
+			t.Fatalf("newDyndns2Provider failed: %v", err)
+		}
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+		if result.Code != expected {
+			t.Errorf("answer %q expected %s but got %s", answer, expected, result.Code)
+		}
+	}
+	if fake.agents[0] != dyndns2UserAgent {
+		t.Errorf("unexpected user agent %q", fake.agents[0])
+	}
+}
+
+func TestDyndns2ProviderPresets(t *testing.T) {
+	ips := []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}
+
+	testCases := []map[string]interface{}{
+		{"preset": providerNoIP, "user": "u", "queries": []string{"hostname=home.example.com&myip=203.0.113.7%2C2001%3Adb8%3A%3A1"}},
+		{"preset": providerDynu, "user": "u", "queries": []string{"hostname=home.example.com&myip=203.0.113.7&myipv6=2001%3Adb8%3A%3A1"}},
+		{"preset": providerHE, "user": "", "queries": []string{"hostname=home.example.com&myip=203.0.113.7", "hostname=home.example.com&myip=2001%3Adb8%3A%3A1"}},
+		{"preset": providerDyndns2, "user": "u", "extra": `"host-param": "host", `, "queries": []string{"host=home.example.com&myip=203.0.113.7%2C2001%3Adb8%3A%3A1"}},
+	}
+
+	for _, testCase := range testCases {
+		fake := newFakeDyndns2(t)
+		extra, _ := testCase["extra"].(string)
+		provider, err := newDyndns2Provider(testCase["preset"].(string))("user", gjson.Parse(`{`+extra+`
+			"dd-user": "`+testCase["user"].(string)+`", "dd-pass": "good", "server": "`+fake.server.URL+`/nic/update"
+		}`))
+		if err != nil {
+			t.Fatalf("newDyndns2Provider(%s) failed: %v", testCase["preset"], err)
+		}
+		if _, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: ips}); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+		expected := testCase["queries"].([]string)
+		if strings.Join(fake.queries, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s sent %v, expected %v", testCase["preset"], fake.queries, expected)
+		}
+		if testCase["user"] == "" && fake.users[0] != "home.example.com" {
+			t.Errorf("%s authenticated as %q instead of the hostname", testCase["preset"], fake.users[0])
+		}
+	}
+}
+
+func TestDyndns2ProviderHaltsAfterFatalAnswer(t *testing.T) {
+	fake := newFakeDyndns2(t)
+	provider, err := newDyndns2Provider(providerDyn)("user", gjson.Parse(`{
+		"dd-user": "u", "dd-pass": "abuse", "server": "`+fake.server.URL+`/v3/update"
+	}`))
+	if err != nil {
+		t.Fatalf("newDyndns2Provider failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != ResultAbuse {
+			t.Fatalf("update %d expected %s but got %v, %v", i, ResultAbuse, result, err)
+		}
+	}
+	if fake.requests != 1 {
+		t.Errorf("service was called %d times after abuse", fake.requests)
+	}
+
+	// the service is called again once the halt is over
+	dyndns2 := provider.(*dyndns2Provider)
+	dyndns2.haltedUntil = time.Now().Add(-time.Second)
+	if _, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}}); err != nil {
+		t.Fatalf("update after the halt failed: %v", err)
+	}
+	if fake.requests != 2 {
+		t.Errorf("service expected 2 calls after the halt but got %d", fake.requests)
+	}
+	if dyndns2.haltedUntil.Before(time.Now().Add(dyndns2HaltDuration - time.Minute)) {
+		t.Errorf("a new fatal answer must halt the provider again")
+	}
+
+	// 911 and dnserr halt the provider for the shorter wait of the protocol
+	for _, answer := range []string{"911", "dnserr"} {
+		fake := newFakeDyndns2(t)
+		provider, err := newDyndns2Provider(providerNoIP)("user", gjson.Parse(`{
+			"dd-user": "u", "dd-pass": "`+answer+`", "server": "`+fake.server.URL+`/nic/update"
+		}`))
+		if err != nil {
+			t.Fatalf("newDyndns2Provider failed: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}}); err != nil {
+				t.Fatalf("update after %s failed: %v", answer, err)
+			}
+		}
+		until := provider.(*dyndns2Provider).haltedUntil
+		if fake.requests != 1 || until.After(time.Now().Add(dyndns2ServerErrorHaltDuration)) || until.Before(time.Now().Add(dyndns2ServerErrorHaltDuration-time.Minute)) {
+			t.Errorf("%s expected one call and a halt of %s but got %d calls until %s", answer, dyndns2ServerErrorHaltDuration, fake.requests, until)
+		}
+	}
+}
+
+func TestDyndns2ProviderWithoutAddresses(t *testing.T) {
+	fake := newFakeDyndns2(t)
+	provider, err := newDyndns2Provider(providerHE)("user", gjson.Parse(`{
+		"dd-pass": "good", "server": "`+fake.server.URL+`/nic/update"
+	}`))
+	if err != nil {
+		t.Fatalf("newDyndns2Provider failed: %v", err)
+	}
+	result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com"})
+	if err != nil || result.Code != ResultServerError || fake.requests != 0 {
+		t.Errorf("update without addresses expected %s and no call but got %v, %v, %d calls", ResultServerError, result, err, fake.requests)
+	}
+}
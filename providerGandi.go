@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerGandi = "gandi"
+
+	defaultGandiAPI = "https://api.gandi.net/v5/livedns"
+	defaultGandiTTL = 300
+)
+
+// gandiProvider replaces the A and AAAA RRsets of a host in Gandi LiveDNS,
+// authenticating with a personal access token. The TTL of an existing RRset
+// is kept unless set in the entry.
+type gandiProvider struct {
+	api    string
+	token  string
+	domain string
+	ttl    int64
+}
+
+type gandiRRset struct {
+	TTL    int64    `json:"rrset_ttl"`
+	Values []string `json:"rrset_values"`
+}
+
+func newGandiProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &gandiProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token:  entry.Get("token").String(),
+		domain: strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:    entry.Get("ttl").Int(),
+	}
+	if p.api == "" {
+		p.api = defaultGandiAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("gandi provider of %s needs a token", username)
+	}
+	// LiveDNS refuses TTLs under 300 seconds
+	if p.ttl != 0 && (p.ttl < 300 || p.ttl > 2592000) {
+		return nil, fmt.Errorf("invalid ttl of %s, it must be between 300 and 2592000", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *gandiProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	name := relativeRecordName(req.Host, domain)
+
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		path := "/domains/" + url.PathEscape(domain) + "/records/" + url.PathEscape(name) + "/" + record.Type
+		rrset := gandiRRset{TTL: defaultGandiTTL, Values: []string{record.IP}}
+		var current gandiRRset
+		err := p.do(ctx, http.MethodGet, path, nil, &current)
+		var apiErr *providerAPIError
+		switch {
+		case err == nil:
+			rrset.TTL = current.TTL
+		case !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound:
+			return resultFromError(err)
+		}
+		if p.ttl > 0 {
+			rrset.TTL = p.ttl
+		}
+
+		outcome := "unchanged"
+		if err != nil || current.TTL != rrset.TTL || len(current.Values) != 1 || current.Values[0] != record.IP {
+			if err := p.do(ctx, http.MethodPut, path, rrset, nil); err != nil {
+				return resultFromError(err)
+			}
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// domainFor returns the configured domain, or the longest LiveDNS domain host is in
+func (p *gandiProvider) domainFor(ctx context.Context, host string) (string, error) {
+	if p.domain != "" {
+		return p.domain, nil
+	}
+	key := "gandi/domain/" + host
+	var domain string
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	for _, name := range zoneCandidates(host) {
+		err := p.do(ctx, http.MethodGet, "/domains/"+url.PathEscape(name), nil, nil)
+		if err == nil {
+			getState().Set(key, name, 24*time.Hour)
+			return name, nil
+		}
+		// domains of other accounts answer 403
+		var apiErr *providerAPIError
+		if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusNotFound && apiErr.StatusCode != http.StatusForbidden) {
+			return "", err
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Gandi LiveDNS domain found for " + host}
+}
+
+// do calls the API and decodes the JSON answer into out
+func (p *gandiProvider) do(ctx context.Context, method, path string, in, out interface{}) error {
+	call := jsonCall{provider: "Gandi", method: method, url: p.api + path, in: in,
+		header: http.Header{"Authorization": {"Bearer " + p.token}, "Accept": {"application/json"}}}
+	data, err := doJSONCall(ctx, nil, call, func(apiErr *providerAPIError, data []byte) {
+		answer := gjson.ParseBytes(data)
+		if message := answer.Get("message").String(); message != "" {
+			apiErr.Message = message
+			if descriptions := answer.Get("errors.#.description").Array(); len(descriptions) > 0 {
+				apiErr.Message += ": " + descriptions[0].String()
+			}
+		}
+		if apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusConflict {
+			apiErr.Code = ResultServerError
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid Gandi answer: %v", err)
+		}
+	}
+	return nil
+}
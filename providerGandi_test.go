@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGandiProvider(t *testing.T) {
+	var writes []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pat_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code": 401, "message": "The server could not verify that you are authorized to access the requested resource.", "object": "HTTPUnauthorized", "cause": "Unauthorized"}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPut && strings.Contains(string(body), "203.0.113.255"):
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code": 400, "message": "Validation error", "object": "HTTPBadRequest", "errors": [{"location": "body", "name": "rrset_values", "description": "invalid value"}]}`))
+		case r.Method == http.MethodPut:
+			writes = append(writes, r.URL.Path+" "+string(body))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"message": "DNS Record Created"}`))
+		case r.URL.Path == "/v5/livedns/domains/example.com":
+			_, _ = w.Write([]byte(`{"fqdn": "example.com", "automatic_snapshots": true}`))
+		case r.URL.Path == "/v5/livedns/domains/other.example.com":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"code": 403, "message": "Access was denied to this resource.", "object": "HTTPForbidden", "cause": "Forbidden"}`))
+		case r.URL.Path == "/v5/livedns/domains/example.com/records/home/A":
+			_, _ = w.Write([]byte(`{"rrset_name": "home", "rrset_type": "A", "rrset_ttl": 1800, "rrset_values": ["198.51.100.1"]}`))
+		case r.URL.Path == "/v5/livedns/domains/example.com/records/@/A":
+			_, _ = w.Write([]byte(`{"rrset_name": "@", "rrset_type": "A", "rrset_ttl": 10800, "rrset_values": ["203.0.113.7"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code": 404, "message": "The resource could not be found.", "object": "HTTPNotFound", "cause": "Not Found"}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(entry string) Provider {
+		return newTestProvider(t, newGandiProvider, `{"api-url": "`+api.URL+`/v5/livedns", `+entry+`}`)
+	}
+
+	result, err := fromEntry(`"token": "pat_token"`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.net", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultNoHost {
+		t.Errorf("update outside the domains expected %s but got %v, %v", ResultNoHost, result, err)
+	}
+	result, err = fromEntry(`"token": "pat_token"`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.com.", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		`/v5/livedns/domains/example.com/records/home/A {"rrset_ttl":1800,"rrset_values":["203.0.113.7"]}`,
+		`/v5/livedns/domains/example.com/records/home/AAAA {"rrset_ttl":300,"rrset_values":["2001:db8::1"]}`,
+	}
+	if strings.Join(writes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected record writes:\n%s", strings.Join(writes, "\n"))
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		ip    string
+		code  ResultCode
+	}{
+		"apex unchanged": {`"token": "pat_token", "domain": "example.com"`, "example.com", "203.0.113.7", ResultNoChange},
+		"new ttl":        {`"token": "pat_token", "domain": "example.com", "ttl": 600`, "example.com", "203.0.113.7", ResultGood},
+		"bad token":      {`"token": "wrong", "domain": "example.com"`, "home.example.com", "203.0.113.7", ResultBadAuth},
+		"refused value":  {`"token": "pat_token", "domain": "example.com"`, "home.example.com", "203.0.113.255", ResultServerError},
+	}
+	for name, testCase := range testCases {
+		result, err := fromEntry(testCase.entry).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP(testCase.ip)}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerGcloud = "gcloud"
+
+	defaultGcloudAPI      = "https://dns.googleapis.com/dns/v1"
+	defaultGcloudTokenURL = "https://oauth2.googleapis.com/token"
+	gcloudScope           = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+	gcloudJWTGrantType    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	defaultGcloudTTL      = 300
+	// tokens are renewed this long before they expire
+	gcloudTokenMargin = time.Minute
+)
+
+// gcloudProvider updates the A and AAAA records of a host in Google Cloud
+// DNS. The service-account key signs a JWT which is exchanged for an OAuth
+// access token, kept until shortly before it expires. Each update is a
+// change deleting the current RRsets and adding the new ones.
+type gcloudProvider struct {
+	api         string
+	tokenURL    string
+	project     string
+	managedZone string
+	ttl         int64
+	email       string
+	keyID       string
+	key         *rsa.PrivateKey
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+type gcloudRRset struct {
+	Kind    string   `json:"kind,omitempty"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+func newGcloudProvider(username string, entry gjson.Result) (Provider, error) {
+	account := entry.Get("service-account")
+	if file := entry.Get("service-account-file").String(); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading service-account-file of %s: %v", username, err)
+		}
+		account = gjson.ParseBytes(data)
+	} else if account.Type == gjson.String {
+		account = gjson.Parse(account.String())
+	}
+	if !account.IsObject() {
+		return nil, fmt.Errorf("gcloud provider of %s needs a service-account or service-account-file", username)
+	}
+	if accountType := account.Get("type").String(); accountType != "service_account" {
+		return nil, fmt.Errorf("service account key of %s has type %q, expected service_account", username, accountType)
+	}
+
+	p := &gcloudProvider{
+		api:         strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		tokenURL:    entry.Get("token-url").String(),
+		project:     entry.Get("project").String(),
+		managedZone: entry.Get("managed-zone").String(),
+		ttl:         defaultGcloudTTL,
+		email:       account.Get("client_email").String(),
+		keyID:       account.Get("private_key_id").String(),
+	}
+	privateKey := account.Get("private_key").String()
+	var err error
+	if p.key, err = parseRSAPrivateKey(privateKey); err != nil {
+		return nil, fmt.Errorf("service account key of %s: %v", username, err)
+	}
+	if p.email == "" {
+		return nil, fmt.Errorf("service account key of %s has no client_email", username)
+	}
+	if p.api == "" {
+		p.api = defaultGcloudAPI
+	}
+	if p.tokenURL == "" {
+		p.tokenURL = account.Get("token_uri").String()
+	}
+	if p.tokenURL == "" {
+		p.tokenURL = defaultGcloudTokenURL
+	}
+	if p.project == "" {
+		p.project = account.Get("project_id").String()
+	}
+	if p.project == "" {
+		return nil, fmt.Errorf("gcloud provider of %s needs a project", username)
+	}
+	if ttl := entry.Get("ttl"); ttl.Exists() {
+		if ttl.Int() <= 0 {
+			return nil, fmt.Errorf("invalid ttl of %s", username)
+		}
+		p.ttl = ttl.Int()
+	}
+	registerSecrets(privateKey)
+	return p, nil
+}
+
+func (p *gcloudProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zone, err := p.managedZoneFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	zonePath := "/projects/" + url.PathEscape(p.project) + "/managedZones/" + url.PathEscape(zone)
+	name := canonicalDNSName(req.Host)
+
+	var change struct {
+		Additions []gcloudRRset `json:"additions"`
+		Deletions []gcloudRRset `json:"deletions,omitempty"`
+	}
+	for _, record := range req.AddressRecords() {
+		data, err := p.do(ctx, http.MethodGet, zonePath+"/rrsets", url.Values{"name": {name}, "type": {record.Type}}, nil)
+		if err != nil {
+			return resultFromError(err)
+		}
+		var answer struct {
+			RRsets []gcloudRRset `json:"rrsets"`
+		}
+		if err := json.Unmarshal(data, &answer); err != nil {
+			return nil, fmt.Errorf("invalid Cloud DNS answer: %v", err)
+		}
+		rrset := gcloudRRset{Name: name, Type: record.Type, TTL: p.ttl, RRDatas: []string{record.IP}}
+		if len(answer.RRsets) > 0 {
+			current := answer.RRsets[0]
+			if current.TTL == rrset.TTL && len(current.RRDatas) == 1 && current.RRDatas[0] == record.IP {
+				continue
+			}
+			// a deletion must match the current RRset exactly
+			current.Kind = ""
+			change.Deletions = append(change.Deletions, current)
+		}
+		change.Additions = append(change.Additions, rrset)
+	}
+	if len(change.Additions) == 0 {
+		return &UpdateResult{Code: ResultNoChange, StatusCode: http.StatusOK, Body: fmt.Sprintf("%s %s", req.Host, strings.Join(req.IPStrings(), ","))}, nil
+	}
+
+	data, err := p.do(ctx, http.MethodPost, zonePath+"/changes", nil, change)
+	if err != nil {
+		return resultFromError(err)
+	}
+	return &UpdateResult{
+		Code:       ResultGood,
+		StatusCode: http.StatusOK,
+		Body:       fmt.Sprintf("%s %s %s", gjson.GetBytes(data, "id").String(), gjson.GetBytes(data, "status").String(), strings.Join(req.IPStrings(), ",")),
+	}, nil
+}
+
+// managedZoneFor returns the configured managed zone, or finds the zone of host by its DNS name
+func (p *gcloudProvider) managedZoneFor(ctx context.Context, host string) (string, error) {
+	if p.managedZone != "" {
+		return p.managedZone, nil
+	}
+	key := "gcloud/zone/" + p.project + "/" + host
+	var zone string
+	if getState().Get(key, &zone) {
+		return zone, nil
+	}
+	for _, name := range zoneCandidates(host) {
+		data, err := p.do(ctx, http.MethodGet, "/projects/"+url.PathEscape(p.project)+"/managedZones", url.Values{"dnsName": {canonicalDNSName(name)}}, nil)
+		if err != nil {
+			return "", err
+		}
+		// private zones may share the DNS name of the public one
+		for _, zone := range gjson.GetBytes(data, "managedZones").Array() {
+			if visibility := zone.Get("visibility").String(); visibility == "" || visibility == "public" {
+				getState().Set(key, zone.Get("name").String(), 24*time.Hour)
+				return zone.Get("name").String(), nil
+			}
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Cloud DNS managed zone found for " + host}
+}
+
+// accessToken returns the cached access token or gets a new one. The lock is
+// not held during the token request, so a slow token endpoint does not hold
+// up the updates that still have a token.
+func (p *gcloudProvider) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	token, expiry := p.token, p.tokenExpiry
+	p.mu.Unlock()
+	if token != "" && time.Now().Before(expiry) {
+		return token, nil
+	}
+
+	token, expiry, err := p.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	replaceSecret(p.token, token)
+	p.token, p.tokenExpiry = token, expiry
+	p.mu.Unlock()
+	return token, nil
+}
+
+// requestToken gets a new access token with a signed JWT
+func (p *gcloudProvider) requestToken(ctx context.Context) (string, time.Time, error) {
+	now := time.Now()
+	assertion, err := signJWT(map[string]interface{}{
+		"iss":   p.email,
+		"scope": gcloudScope,
+		"aud":   p.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}, p.key, p.keyID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error signing the Cloud DNS token request: %v", err)
+	}
+	form := url.Values{"grant_type": {gcloudJWTGrantType}, "assertion": {assertion}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error building the Cloud DNS token request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	getLogger().Debugf("Getting a Cloud DNS access token for %s", p.email)
+	resp, data, err := doProviderRequest(httpReq)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error getting a Cloud DNS access token: %v", err)
+	}
+	answer := gjson.ParseBytes(data)
+	if resp.StatusCode != http.StatusOK || answer.Get("access_token").String() == "" {
+		apiErr := &providerAPIError{Code: ResultBadAuth, StatusCode: resp.StatusCode, Message: string(data)}
+		if description := answer.Get("error_description").String(); description != "" {
+			apiErr.Message = answer.Get("error").String() + ": " + description
+		}
+		if resp.StatusCode/100 == 5 {
+			apiErr.Code = ResultServerError
+		}
+		return "", time.Time{}, apiErr
+	}
+	return answer.Get("access_token").String(), now.Add(time.Duration(answer.Get("expires_in").Int())*time.Second - gcloudTokenMargin), nil
+}
+
+// do calls the API with an access token, dropped when the API refuses it
+func (p *gcloudProvider) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	call := jsonCall{provider: "Cloud DNS", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {"Bearer " + token}}}
+	return doJSONCall(ctx, nil, call, func(apiErr *providerAPIError, data []byte) {
+		if apiErr.StatusCode == http.StatusUnauthorized {
+			// the token was revoked or expired early, get a new one next time
+			p.mu.Lock()
+			replaceSecret(p.token, "")
+			p.token = ""
+			p.mu.Unlock()
+		}
+		if message := gjson.GetBytes(data, "error.message").String(); message != "" {
+			apiErr.Message = message
+		}
+	})
+}
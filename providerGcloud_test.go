@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestGcloudProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	tokens := 0
+	var tokenServer *httptest.Server
+	tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if r.PostForm.Get("grant_type") != gcloudJWTGrantType || len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_request", "error_description": "bad assertion"}`))
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil ||
+			gjson.GetBytes(claims, "iss").String() != "ddns@project-1.iam.gserviceaccount.com" ||
+			gjson.GetBytes(claims, "aud").String() != tokenServer.URL+"/token" ||
+			gjson.GetBytes(claims, "scope").String() != gcloudScope ||
+			gjson.GetBytes(header, "kid").String() != "key-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Invalid JWT Signature."}`))
+			return
+		}
+		tokens++
+		_, _ = w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3599, "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	var changes []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ya29.token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"code": 401, "message": "Request had invalid authentication credentials."}}`))
+			return
+		}
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/dns/v1/projects/project-1/managedZones":
+			if query.Get("dnsName") == "example.com." {
+				_, _ = w.Write([]byte(`{"managedZones": [{"name": "internal", "dnsName": "example.com.", "visibility": "private"}, {"name": "example-com", "dnsName": "example.com.", "visibility": "public"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"managedZones": []}`))
+		case "/dns/v1/projects/project-1/managedZones/example-com/rrsets":
+			if query.Get("type") == "A" {
+				_, _ = w.Write([]byte(`{"rrsets": [{"kind": "dns#resourceRecordSet", "name": "home.example.com.", "type": "A", "ttl": 300, "rrdatas": ["198.51.100.1"]}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"rrsets": []}`))
+		case "/dns/v1/projects/project-1/managedZones/example-com/changes":
+			body, _ := io.ReadAll(r.Body)
+			changes = append(changes, string(body))
+			_, _ = w.Write([]byte(`{"kind": "dns#change", "id": "7", "status": "pending"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "The 'parameters.managedZone' resource named 'other' does not exist."}}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	account, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-1",
+		"private_key_id": "key-1",
+		"private_key":    privateKey,
+		"client_email":   "ddns@project-1.iam.gserviceaccount.com",
+		"token_uri":      tokenServer.URL + "/token",
+	})
+	fromEntry := func(extra string) Provider {
+		return newTestProvider(t, newGcloudProvider, `{"service-account": `+string(account)+`, "api-url": "`+api.URL+`/dns/v1"`+extra+`}`)
+	}
+
+	provider := fromEntry("")
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+	result, err := provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := `{"additions":[{"name":"home.example.com.","type":"A","ttl":300,"rrdatas":["203.0.113.7"]},` +
+		`{"name":"home.example.com.","type":"AAAA","ttl":300,"rrdatas":["2001:db8::1"]}],` +
+		`"deletions":[{"name":"home.example.com.","type":"A","ttl":300,"rrdatas":["198.51.100.1"]}]}`
+	if len(changes) != 1 || changes[0] != expected {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	// the token is reused and the record is already set
+	result, err = provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("198.51.100.1")}})
+	if err != nil || result.Code != ResultNoChange || tokens != 1 {
+		t.Errorf("second update expected %s with one token but got %v, %v and %d tokens", ResultNoChange, result, err, tokens)
+	}
+
+	testCases := map[string]struct {
+		extra string
+		host  string
+		code  ResultCode
+	}{
+		"no zone":      {"", "home.example.org", ResultNoHost},
+		"unknown zone": {`, "managed-zone": "other"`, "home.example.com", ResultNoHost},
+		"bad token":    {`, "token-url": "` + tokenServer.URL + `/other"`, "home.example.com", ResultBadAuth},
+	}
+	for name, testCase := range testCases {
+		result, err := fromEntry(testCase.extra).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+}
+
+func TestGcloudTokenRequestDoesNotBlock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	requested, release := make(chan struct{}, 2), make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte(`{"access_token": "ya29.slow-token", "expires_in": 3599}`))
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	account, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "project-1",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email": "ddns@project-1.iam.gserviceaccount.com",
+		"token_uri":    tokenServer.URL + "/token",
+	})
+	provider := newTestProvider(t, newGcloudProvider, `{"service-account": `+string(account)+`}`).(*gcloudProvider)
+	go func() { _, _ = provider.accessToken(localProviderContext()) }()
+	<-requested
+
+	// a second update gives up with its own context instead of waiting for the first
+	ctx, cancel := context.WithTimeout(localProviderContext(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.accessToken(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected the canceled token request to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the token request waited for another one")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerHetzner = "hetzner"
+
+	defaultHetznerAPI = "https://api.hetzner.cloud/v1"
+)
+
+// hetznerProvider updates the A and AAAA RRsets of a host through the DNS
+// part of the Hetzner Cloud API. The zone ID is cached in the state store;
+// RRsets are addressed by name and type. RRsets keep their TTL unless set in
+// the entry, new ones use the default TTL of the zone.
+type hetznerProvider struct {
+	api   string
+	token string
+	zone  string
+	ttl   int64
+	limit *providerRateLimit
+}
+
+type hetznerRRset struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	TTL     *int64          `json:"ttl,omitempty"`
+	Records []hetznerRecord `json:"records"`
+}
+
+type hetznerRecord struct {
+	Value string `json:"value"`
+}
+
+func newHetznerProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &hetznerProvider{
+		api:   strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token: entry.Get("token").String(),
+		zone:  strings.TrimSuffix(strings.ToLower(entry.Get("zone").String()), "."),
+		ttl:   entry.Get("ttl").Int(),
+		limit: &providerRateLimit{name: "Hetzner"},
+	}
+	if p.api == "" {
+		p.api = defaultHetznerAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("hetzner provider of %s needs a token", username)
+	}
+	if p.ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl of %s", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *hetznerProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zoneID, zone, err := p.zoneFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	name := relativeRecordName(req.Host, zone)
+
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, fmt.Sprintf("/zones/%d/rrsets", zoneID), name, record)
+		if err != nil {
+			var apiErr *providerAPIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				// the cached zone may be gone
+				getState().Delete("hetzner/zone/" + req.Host)
+			}
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// hetznerZone is the cached ID and name of the zone of a host
+type hetznerZone struct {
+	ID   int64
+	Name string
+}
+
+// zoneFor finds the zone of host, the configured one or the longest zone host is in
+func (p *hetznerProvider) zoneFor(ctx context.Context, host string) (int64, string, error) {
+	key := "hetzner/zone/" + host
+	var zone hetznerZone
+	if getState().Get(key, &zone) {
+		return zone.ID, zone.Name, nil
+	}
+	candidates := zoneCandidates(host)
+	if p.zone != "" {
+		candidates = []string{p.zone}
+	}
+	for _, name := range candidates {
+		data, err := p.do(ctx, http.MethodGet, "/zones", url.Values{"name": {name}}, nil)
+		if err != nil {
+			return 0, "", err
+		}
+		if zones := gjson.GetBytes(data, "zones").Array(); len(zones) > 0 {
+			zone = hetznerZone{ID: zones[0].Get("id").Int(), Name: name}
+			getState().Set(key, zone, 24*time.Hour)
+			return zone.ID, zone.Name, nil
+		}
+	}
+	return 0, "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Hetzner DNS zone found for " + host}
+}
+
+// upsert sets the RRset of name to the address, creating it if needed, and
+// reports whether anything changed
+func (p *hetznerProvider) upsert(ctx context.Context, rrsetsPath, name string, address addressRecord) (bool, error) {
+	rrsetPath := rrsetsPath + "/" + url.PathEscape(name) + "/" + address.Type
+	data, err := p.do(ctx, http.MethodGet, rrsetPath, nil, nil)
+	var apiErr *providerAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		rrset := hetznerRRset{Name: name, Type: address.Type, Records: []hetznerRecord{{Value: address.IP}}}
+		if p.ttl > 0 {
+			rrset.TTL = &p.ttl
+		}
+		_, err := p.do(ctx, http.MethodPost, rrsetsPath, nil, rrset)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var current hetznerRRset
+	if err := json.Unmarshal([]byte(gjson.GetBytes(data, "rrset").Raw), &current); err != nil {
+		return false, fmt.Errorf("invalid Hetzner answer: %v", err)
+	}
+	changed := false
+	if len(current.Records) != 1 || current.Records[0].Value != address.IP {
+		change := map[string]interface{}{"records": []hetznerRecord{{Value: address.IP}}}
+		if _, err := p.do(ctx, http.MethodPost, rrsetPath+"/actions/set_records", nil, change); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if p.ttl > 0 && (current.TTL == nil || *current.TTL != p.ttl) {
+		if _, err := p.do(ctx, http.MethodPost, rrsetPath+"/actions/change_ttl", nil, map[string]int64{"ttl": p.ttl}); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// do calls the API; unauthorized and forbidden error codes are badauth
+func (p *hetznerProvider) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	call := jsonCall{provider: "Hetzner", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {"Bearer " + p.token}}}
+	return doJSONCall(ctx, p.limit, call, func(apiErr *providerAPIError, data []byte) {
+		code := gjson.GetBytes(data, "error.code").String()
+		if message := gjson.GetBytes(data, "error.message").String(); message != "" {
+			apiErr.Message = code + ": " + message
+		}
+		if code == "unauthorized" || code == "forbidden" {
+			apiErr.Code = ResultBadAuth
+		}
+	})
+}
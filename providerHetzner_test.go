@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHetznerProvider(t *testing.T) {
+	var calls []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hcloud_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"code": "unauthorized", "message": "unable to authenticate"}}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		calls = append(calls, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		w.Header().Set("RateLimit-Remaining", "3599")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1/zones":
+			if r.URL.Query().Get("name") == "example.com" {
+				_, _ = w.Write([]byte(`{"zones": [{"id": 42, "name": "example.com", "ttl": 3600}], "meta": {"pagination": {"page": 1, "last_page": 1}}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"zones": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`))
+		case "GET /v1/zones/42/rrsets/home/A":
+			_, _ = w.Write([]byte(`{"rrset": {"id": "home/A", "name": "home", "type": "A", "ttl": 120, "records": [{"value": "198.51.100.1", "comment": ""}]}}`))
+		case "GET /v1/zones/42/rrsets/@/A":
+			_, _ = w.Write([]byte(`{"rrset": {"id": "@/A", "name": "@", "type": "A", "ttl": null, "records": [{"value": "203.0.113.7"}]}}`))
+		case "POST /v1/zones/42/rrsets/home/A/actions/set_records", "POST /v1/zones/42/rrsets", "POST /v1/zones/42/rrsets/@/A/actions/change_ttl":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"action": {"id": 1, "status": "running"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": "not_found", "message": "rrset not found"}}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(entry string) Provider {
+		return newTestProvider(t, newHetznerProvider, `{"api-url": "`+api.URL+`/v1", `+entry+`}`)
+	}
+
+	result, err := fromEntry(`"token": "hcloud_token"`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		"GET /v1/zones?name=home.example.com",
+		"GET /v1/zones?name=example.com",
+		"GET /v1/zones/42/rrsets/home/A",
+		`POST /v1/zones/42/rrsets/home/A/actions/set_records {"records":[{"value":"203.0.113.7"}]}`,
+		"GET /v1/zones/42/rrsets/home/AAAA",
+		`POST /v1/zones/42/rrsets {"name":"home","type":"AAAA","records":[{"value":"2001:db8::1"}]}`,
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected API calls:\n%s", strings.Join(calls, "\n"))
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		code  ResultCode
+		last  string
+	}{
+		"apex unchanged": {`"token": "hcloud_token", "zone": "example.com"`, "example.com", ResultNoChange, "GET /v1/zones/42/rrsets/@/A"},
+		"apex ttl":       {`"token": "hcloud_token", "zone": "example.com", "ttl": 60`, "example.com", ResultGood, `POST /v1/zones/42/rrsets/@/A/actions/change_ttl {"ttl":60}`},
+		"bad token":      {`"token": "wrong"`, "home.example.org", ResultBadAuth, ""},
+		"no zone":        {`"token": "hcloud_token"`, "home.example.org", ResultNoHost, "GET /v1/zones?name=example.org"},
+	}
+	for name, testCase := range testCases {
+		calls = nil
+		getState().DeletePrefix("hetzner/")
+		result, err := fromEntry(testCase.entry).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+		if testCase.last != "" && (len(calls) == 0 || calls[len(calls)-1] != testCase.last) {
+			t.Errorf("%s expected last call %s but got %v", name, testCase.last, calls)
+		}
+	}
+}
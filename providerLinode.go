@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerLinode = "linode"
+
+	defaultLinodeAPI = "https://api.linode.com/v4"
+	linodePageSize   = "500"
+)
+
+// linodeProvider updates the A and AAAA records of a host in Linode (Akamai
+// Cloud) DNS. The domain and record IDs are cached in the state store, so
+// an update of a known record is a single PUT.
+type linodeProvider struct {
+	api    string
+	token  string
+	domain string
+	ttl    int64
+	limit  *providerRateLimit
+}
+
+type linodeRecord struct {
+	ID     int64  `json:"id,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	TTL    int64  `json:"ttl_sec,omitempty"`
+}
+
+// linodeDomain is the cached ID and name of the domain of a host
+type linodeDomain struct {
+	ID   int64
+	Name string
+}
+
+func newLinodeProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &linodeProvider{
+		api:    strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		token:  entry.Get("token").String(),
+		domain: strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:    entry.Get("ttl").Int(),
+		limit:  &providerRateLimit{name: "Linode"},
+	}
+	if p.api == "" {
+		p.api = defaultLinodeAPI
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("linode provider of %s needs a token", username)
+	}
+	if p.ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl of %s", username)
+	}
+	registerSecrets(p.token)
+	return p, nil
+}
+
+func (p *linodeProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, domain, req.Host, record, true)
+		if err != nil {
+			var apiErr *providerAPIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				// the cached domain may be gone
+				getState().Delete("linode/domain/" + req.Host)
+			}
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// domainFor finds the domain of host, the configured one or the longest domain of the account host is in
+func (p *linodeProvider) domainFor(ctx context.Context, host string) (linodeDomain, error) {
+	key := "linode/domain/" + host
+	var domain linodeDomain
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	candidates := zoneCandidates(host)
+	if p.domain != "" {
+		candidates = []string{p.domain}
+	}
+	var domains []linodeDomain
+	err := p.list(ctx, "/domains", func(item gjson.Result) bool {
+		domains = append(domains, linodeDomain{ID: item.Get("id").Int(), Name: strings.ToLower(item.Get("domain").String())})
+		return false
+	})
+	if err != nil {
+		return linodeDomain{}, err
+	}
+	for _, name := range candidates {
+		for _, domain := range domains {
+			if domain.Name == name {
+				getState().Set(key, domain, 24*time.Hour)
+				return domain, nil
+			}
+		}
+	}
+	return linodeDomain{}, &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Linode domain found for " + host}
+}
+
+// upsert sets the record of host to the address, creating it if needed, and
+// reports whether anything changed. A cached record ID that no longer
+// exists is dropped and the record looked up again once.
+func (p *linodeProvider) upsert(ctx context.Context, domain linodeDomain, host string, address addressRecord, useCache bool) (bool, error) {
+	key := fmt.Sprintf("linode/record/%d/%s/%s", domain.ID, host, address.Type)
+	recordsPath := fmt.Sprintf("/domains/%d/records", domain.ID)
+	// the apex record has an empty name
+	name := relativeRecordName(host, domain.Name)
+	if name == "@" {
+		name = ""
+	}
+	var recordID int64
+	if !useCache || !getState().Get(key, &recordID) {
+		var current *linodeRecord
+		err := p.list(ctx, recordsPath, func(item gjson.Result) bool {
+			if item.Get("type").String() == address.Type && strings.EqualFold(item.Get("name").String(), name) {
+				current = &linodeRecord{}
+				_ = json.Unmarshal([]byte(item.Raw), current)
+				return true
+			}
+			return false
+		})
+		if err != nil {
+			return false, err
+		}
+		if current != nil {
+			recordID = current.ID
+			getState().Set(key, recordID, 0)
+			if current.Target == address.IP && (p.ttl == 0 || current.TTL == p.ttl) {
+				return false, nil
+			}
+		}
+	}
+
+	if recordID != 0 {
+		_, err := p.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", recordsPath, recordID), nil, linodeRecord{Name: name, Target: address.IP, TTL: p.ttl})
+		var apiErr *providerAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && useCache {
+			getState().Delete(key)
+			return p.upsert(ctx, domain, host, address, false)
+		}
+		return err == nil, err
+	}
+
+	data, err := p.do(ctx, http.MethodPost, recordsPath, nil, linodeRecord{Type: address.Type, Name: name, Target: address.IP, TTL: p.ttl})
+	if err != nil {
+		return false, err
+	}
+	getState().Set(key, gjson.GetBytes(data, "id").Int(), 0)
+	return true, nil
+}
+
+// list calls visit with the items of all pages of path, until visit returns true
+func (p *linodeProvider) list(ctx context.Context, path string, visit func(gjson.Result) bool) error {
+	for page := 1; ; page++ {
+		data, err := p.do(ctx, http.MethodGet, path, url.Values{"page": {fmt.Sprint(page)}, "page_size": {linodePageSize}}, nil)
+		if err != nil {
+			return err
+		}
+		for _, item := range gjson.GetBytes(data, "data").Array() {
+			if visit(item) {
+				return nil
+			}
+		}
+		if gjson.GetBytes(data, "page").Int() >= gjson.GetBytes(data, "pages").Int() {
+			return nil
+		}
+	}
+}
+
+// do calls the API; the reasons of a refusal are joined into its message
+func (p *linodeProvider) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	call := jsonCall{provider: "Linode", method: method, url: p.api + path, query: query, in: in,
+		header: http.Header{"Authorization": {"Bearer " + p.token}}}
+	return doJSONCall(ctx, p.limit, call, func(apiErr *providerAPIError, data []byte) {
+		var reasons []string
+		for _, reason := range gjson.GetBytes(data, "errors.#.reason").Array() {
+			reasons = append(reasons, reason.String())
+		}
+		if len(reasons) > 0 {
+			apiErr.Message = strings.Join(reasons, "; ")
+		}
+		if apiErr.StatusCode == http.StatusBadRequest {
+			apiErr.Code = ResultServerError
+		}
+	})
+}
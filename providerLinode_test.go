@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLinodeProvider(t *testing.T) {
+	var writes []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer linode_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errors": [{"reason": "Invalid Token"}]}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		page := r.URL.Query().Get("page")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v4/domains":
+			if page == "1" {
+				_, _ = w.Write([]byte(`{"data": [{"id": 1, "domain": "example.org"}], "page": 1, "pages": 2, "results": 2}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": [{"id": 7, "domain": "example.com"}], "page": 2, "pages": 2, "results": 2}`))
+		case "GET /v4/domains/7/records":
+			_, _ = w.Write([]byte(`{"data": [{"id": 70, "type": "A", "name": "home", "target": "198.51.100.1", "ttl_sec": 300},
+				{"id": 71, "type": "A", "name": "", "target": "203.0.113.7", "ttl_sec": 0}], "page": 1, "pages": 1, "results": 2}`))
+		case "PUT /v4/domains/7/records/70", "POST /v4/domains/7/records":
+			if strings.Contains(string(body), "203.0.113.255") {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors": [{"reason": "Invalid target", "field": "target"}]}`))
+				return
+			}
+			writes = append(writes, r.Method+" "+string(body))
+			_, _ = fmt.Fprintf(w, `{"id": %d}`, 72)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors": [{"reason": "Not found"}]}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(entry string) Provider {
+		return newTestProvider(t, newLinodeProvider, `{"api-url": "`+api.URL+`/v4", `+entry+`}`)
+	}
+
+	result, err := fromEntry(`"token": "linode_token"`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		`PUT {"name":"home","target":"203.0.113.7"}`,
+		`POST {"type":"AAAA","name":"home","target":"2001:db8::1"}`,
+	}
+	if strings.Join(writes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected record writes:\n%s", strings.Join(writes, "\n"))
+	}
+	var domain linodeDomain
+	var recordID int64
+	if !getState().Get("linode/domain/home.example.com", &domain) || domain.ID != 7 ||
+		!getState().Get("linode/record/7/home.example.com/AAAA", &recordID) || recordID != 72 {
+		t.Errorf("IDs not cached: %v %d", domain, recordID)
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		ip    string
+		code  ResultCode
+	}{
+		"apex unchanged": {`"token": "linode_token", "domain": "example.com"`, "example.com", "203.0.113.7", ResultNoChange},
+		"bad token":      {`"token": "wrong"`, "home.example.net", "203.0.113.7", ResultBadAuth},
+		"no domain":      {`"token": "linode_token"`, "home.example.net", "203.0.113.7", ResultNoHost},
+		"refused value":  {`"token": "linode_token", "domain": "example.com"`, "home.example.com", "203.0.113.255", ResultServerError},
+	}
+	for name, testCase := range testCases {
+		result, err := fromEntry(testCase.entry).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP(testCase.ip)}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerMulti = "multi"
+
+	multiModeMirror   = "mirror"
+	multiModeFailover = "failover"
+)
+
+// multiProvider publishes a host through several providers. In mirror mode
+// all of them are updated at once and the update succeeds when at least
+// quorum of them succeed; in failover mode they are tried in order until one
+// succeeds. The result of every provider called is reported in Providers.
+// A provider with its own proxy or outbound policy keys is called with them
+// instead of those of the credential entry.
+type multiProvider struct {
+	mode      string
+	quorum    int
+	names     []string
+	providers []Provider
+	proxies   []*ProxySettings
+	policies  []*OutboundPolicy
+}
+
+func newMultiProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &multiProvider{mode: strings.ToLower(entry.Get("mode").String())}
+	switch p.mode {
+	case "":
+		p.mode = multiModeMirror
+	case multiModeMirror, multiModeFailover:
+	default:
+		return nil, fmt.Errorf("unknown mode %q of %s, use mirror or failover", p.mode, username)
+	}
+
+	entries := entry.Get("providers").Array()
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("multi provider of %s needs a list of providers", username)
+	}
+	seen := map[string]int{}
+	for i, sub := range entries {
+		if !sub.IsObject() {
+			return nil, fmt.Errorf("provider %d of %s is not an object", i+1, username)
+		}
+		kind := strings.ToLower(strings.TrimSpace(sub.Get("provider").String()))
+		if kind == providerMulti {
+			return nil, fmt.Errorf("provider %d of %s cannot be another multi provider", i+1, username)
+		}
+		provider, err := newProvider(username, sub)
+		if err != nil {
+			return nil, fmt.Errorf("provider %d: %v", i+1, err)
+		}
+		proxy, policy, err := entryOutbound(sub)
+		if err != nil {
+			return nil, fmt.Errorf("provider %d of %s: %v", i+1, username, err)
+		}
+		name := sub.Get("name").String()
+		if name == "" {
+			name = kind
+			if name == "" {
+				name = providerURL
+			}
+		}
+		// the same service may be listed twice with other accounts
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s#%d", name, seen[name])
+		}
+		p.names = append(p.names, name)
+		p.providers = append(p.providers, provider)
+		p.proxies = append(p.proxies, proxy)
+		p.policies = append(p.policies, policy)
+	}
+
+	p.quorum = len(p.providers)
+	if quorum := entry.Get("quorum"); quorum.Exists() {
+		if p.mode != multiModeMirror {
+			return nil, fmt.Errorf("quorum of %s only applies to the mirror mode", username)
+		}
+		if quorum.Int() < 1 || quorum.Int() > int64(len(p.providers)) {
+			return nil, fmt.Errorf("quorum of %s must be between 1 and %d", username, len(p.providers))
+		}
+		p.quorum = int(quorum.Int())
+	}
+	return p, nil
+}
+
+func (p *multiProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	if p.mode == multiModeFailover {
+		return p.failover(ctx, req), nil
+	}
+	return p.mirror(ctx, req), nil
+}
+
+// call runs provider i, turning an error into a 911 result so one failing
+// provider does not hide the answers of the others
+func (p *multiProvider) call(ctx context.Context, i int, req *UpdateRequest) namedResult {
+	ctx = withOutboundPolicy(withUpstreamProxy(ctx, p.proxies[i]), p.policies[i])
+	result, err := p.providers[i].Update(ctx, req)
+	if err != nil {
+		getLogger().Warnf("Error calling provider %s: %v", p.names[i], err)
+		return namedResult{Name: p.names[i], UpdateResult: UpdateResult{Code: ResultServerError, Body: err.Error()}}
+	}
+	return namedResult{Name: p.names[i], UpdateResult: *result}
+}
+
+// mirror updates all providers concurrently
+func (p *multiProvider) mirror(ctx context.Context, req *UpdateRequest) *UpdateResult {
+	results := make([]namedResult, len(p.providers))
+	var wg sync.WaitGroup
+	for i := range p.providers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = p.call(ctx, i, req)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	code := ResultNoChange
+	var failed *namedResult
+	for i := range results {
+		switch {
+		case results[i].Code == ResultGood:
+			succeeded++
+			code = ResultGood
+		case results[i].Success():
+			succeeded++
+		case failed == nil:
+			failed = &results[i]
+		}
+	}
+	result := &UpdateResult{Code: code, Providers: results}
+	if succeeded < p.quorum {
+		result.Code, result.StatusCode = failed.Code, failed.StatusCode
+	}
+	result.Body = fmt.Sprintf("mirror %d/%d succeeded, quorum %d\n%s", succeeded, len(results), p.quorum, summarizeResults(results))
+	return result
+}
+
+// failover tries the providers in order until one succeeds
+func (p *multiProvider) failover(ctx context.Context, req *UpdateRequest) *UpdateResult {
+	var results []namedResult
+	for i := range p.providers {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, p.call(ctx, i, req))
+		if results[i].Success() {
+			break
+		}
+	}
+	if len(results) == 0 {
+		return &UpdateResult{Code: ResultServerError, Body: "failover called no provider: " + ctx.Err().Error()}
+	}
+	last := results[len(results)-1]
+	return &UpdateResult{
+		Code:       last.Code,
+		StatusCode: last.StatusCode,
+		Body:       fmt.Sprintf("failover answered by %s\n%s", last.Name, summarizeResults(results)),
+		Providers:  results,
+	}
+}
+
+// summarizeResults writes one line per provider with the first line of its answer
+func summarizeResults(results []namedResult) string {
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		body, _, _ := strings.Cut(strings.TrimSpace(result.Body), "\n")
+		lines = append(lines, fmt.Sprintf("%s: %s %s", result.Name, result.Code, body))
+	}
+	return strings.Join(lines, "\n")
+}
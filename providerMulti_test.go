@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestMultiProvider(t *testing.T) {
+	var mu sync.Mutex
+	var called []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Query().Get("token"), "token-")
+		mu.Lock()
+		called = append(called, token)
+		mu.Unlock()
+		switch token {
+		case "good":
+			_, _ = w.Write([]byte("OK\n203.0.113.7\n\nUPDATED"))
+		case "same":
+			_, _ = w.Write([]byte("OK\n203.0.113.7\n\nNOCHANGE"))
+		default:
+			_, _ = w.Write([]byte("KO"))
+		}
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	// the server builds these at startup, before updates run concurrently
+	getLogger()
+	getUpstreamClient()
+
+	entry := func(mode, quorum string, tokens ...string) string {
+		var providers []string
+		for _, token := range tokens {
+			target := server.URL
+			if token == "down" {
+				target = down.URL
+			}
+			providers = append(providers, `{"provider": "duckdns", "name": "`+token+`", "token": "token-`+token+`", "server": "`+target+`/update"}`)
+		}
+		return `{"provider": "multi", "mode": "` + mode + `", ` + quorum + `"providers": [` + strings.Join(providers, ", ") + `]}`
+	}
+
+	testCases := map[string]struct {
+		entry  string
+		code   ResultCode
+		called []string
+		codes  []ResultCode
+	}{
+		"mirror all good": {
+			entry: entry("mirror", "", "good", "same"), code: ResultGood,
+			called: []string{"good", "same"}, codes: []ResultCode{ResultGood, ResultNoChange},
+		},
+		"mirror all unchanged": {
+			entry: entry("", "", "same", "same"), code: ResultNoChange,
+			called: []string{"same", "same"}, codes: []ResultCode{ResultNoChange, ResultNoChange},
+		},
+		"mirror below quorum": {
+			entry: entry("mirror", "", "good", "bad"), code: ResultBadAuth,
+			called: []string{"bad", "good"}, codes: []ResultCode{ResultGood, ResultBadAuth},
+		},
+		"mirror quorum met": {
+			entry: entry("mirror", `"quorum": 1, `, "down", "good"), code: ResultGood,
+			called: []string{"good"}, codes: []ResultCode{ResultServerError, ResultGood},
+		},
+		"failover first": {
+			entry: entry("failover", "", "good", "bad"), code: ResultGood,
+			called: []string{"good"}, codes: []ResultCode{ResultGood},
+		},
+		"failover next": {
+			entry: entry("failover", "", "down", "bad", "same", "good"), code: ResultNoChange,
+			called: []string{"bad", "same"}, codes: []ResultCode{ResultServerError, ResultBadAuth, ResultNoChange},
+		},
+		"failover none": {
+			entry: entry("failover", "", "bad", "down"), code: ResultServerError,
+			called: []string{"bad"}, codes: []ResultCode{ResultBadAuth, ResultServerError},
+		},
+	}
+	for name, testCase := range testCases {
+		provider, err := newProvider("user", gjson.Parse(testCase.entry))
+		if err != nil {
+			t.Fatalf("%s: newProvider failed: %v", name, err)
+		}
+		called = nil
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.duckdns.org", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+			continue
+		}
+		sort.Strings(called)
+		if strings.Join(called, ",") != strings.Join(testCase.called, ",") {
+			t.Errorf("%s expected calls %v but got %v", name, testCase.called, called)
+		}
+		var codes []ResultCode
+		for _, sub := range result.Providers {
+			codes = append(codes, sub.Code)
+		}
+		if len(codes) != len(testCase.codes) {
+			t.Errorf("%s expected provider results %v but got %v", name, testCase.codes, codes)
+			continue
+		}
+		for i := range codes {
+			if codes[i] != testCase.codes[i] {
+				t.Errorf("%s expected provider results %v but got %v", name, testCase.codes, codes)
+				break
+			}
+		}
+	}
+}
+
+func TestMultiProviderConfig(t *testing.T) {
+	savedCfg := cfg
+	t.Cleanup(func() { cfg = savedCfg })
+	cfg = &ServerConfig{Upstream: defaultUpstreamConfig}
+	sub := `{"provider": "duckdns", "token": "t"}`
+	testCases := map[string]bool{
+		`{"providers": [` + sub + `]}`:                                  true,
+		`{"mode": "failover", "providers": [` + sub + `, ` + sub + `]}`: true,
+		`{"quorum": 2, "providers": [` + sub + `, ` + sub + `]}`:        true,
+		`{"providers": []}`: false,
+		`{"mode": "random", "providers": [` + sub + `]}`:                                   false,
+		`{"quorum": 3, "providers": [` + sub + `, ` + sub + `]}`:                           false,
+		`{"quorum": 0, "providers": [` + sub + `]}`:                                        false,
+		`{"mode": "failover", "quorum": 1, "providers": [` + sub + `]}`:                    false,
+		`{"providers": [{"provider": "multi", "providers": [` + sub + `]}]}`:               false,
+		`{"providers": [{"provider": "duckdns"}]}`:                                         false,
+		`{"providers": ["duckdns"]}`:                                                       false,
+		`{"providers": [{"provider": " Multi ", "providers": [` + sub + `]}]}`:             false,
+		`{"providers": [{"provider": "duckdns", "token": "t", "allowed-schemes": "ftp"}]}`: false,
+		`{"providers": [{"provider": "duckdns", "token": "t", "proxy": "ftp://proxy"}]}`:   false,
+	}
+	for entry, valid := range testCases {
+		if _, err := newMultiProvider("user", gjson.Parse(entry)); (err == nil) != valid {
+			t.Errorf("%s expected valid %v but got %v", entry, valid, err)
+		}
+	}
+
+	provider, _ := newMultiProvider("user", gjson.Parse(`{"providers": [`+sub+`, `+sub+`, {"provider": "duckdns", "name": "backup", "token": "t"}]}`))
+	if names := strings.Join(provider.(*multiProvider).names, ","); names != "duckdns,duckdns#2,backup" {
+		t.Errorf("unexpected provider names %s", names)
+	}
+}
+
+func TestMultiProviderOutbound(t *testing.T) {
+	savedCfg := cfg
+	t.Cleanup(func() { cfg = savedCfg })
+	cfg = &ServerConfig{Upstream: defaultUpstreamConfig}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK\n203.0.113.7\n\nUPDATED"))
+	}))
+	defer server.Close()
+	getLogger()
+	getUpstreamClient()
+
+	// only the provider allowing private addresses may call the local server
+	sub := `{"provider": "duckdns", "token": "token-good", "server": "` + server.URL + `/update"`
+	provider := newTestProvider(t, newMultiProvider, `{"providers": [`+sub+`, "name": "local", "allow-private": true}, `+sub+`, "name": "public"}]}`)
+	update := &UpdateRequest{Host: "home.duckdns.org", IPs: []net.IP{net.ParseIP("203.0.113.7")}}
+	result, err := provider.Update(context.Background(), update)
+	if err != nil || len(result.Providers) != 2 {
+		t.Fatalf("update expected two provider results but got %v, %v", result, err)
+	}
+	if local, public := result.Providers[0], result.Providers[1]; local.Code != ResultGood || public.Code != ResultServerError {
+		t.Errorf("expected the local provider to succeed alone but got %s %s, %s %s", local.Name, local.Code, public.Name, public.Code)
+	}
+
+	// a failover canceled before its first call answers 911
+	provider = newTestProvider(t, newMultiProvider, `{"mode": "failover", "providers": [`+sub+`}]}`)
+	ctx, cancel := context.WithCancel(localProviderContext())
+	cancel()
+	result, err = provider.Update(ctx, update)
+	if err != nil || result.Code != ResultServerError || len(result.Providers) != 0 {
+		t.Errorf("canceled failover expected %s without provider results but got %v, %v", ResultServerError, result, err)
+	}
+}
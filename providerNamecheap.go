@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerNamecheap = "namecheap"
+
+	defaultNamecheapServer = "https://dynamicdns.park-your-domain.com/update"
+	// response number of a wrong dynamic DNS password
+	namecheapInvalidPassword = "304156"
+)
+
+// namecheapSecondLevelSuffixes are public suffixes of two labels, under which
+// the registered domain has three labels. Domains under other such suffixes
+// need the "domain" key.
+var namecheapSecondLevelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "me.uk": true, "ltd.uk": true, "plc.uk": true,
+	"com.au": true, "net.au": true, "org.au": true,
+	"co.nz": true, "net.nz": true, "org.nz": true,
+	"co.in": true, "net.in": true, "org.in": true,
+	"com.br": true, "com.mx": true, "com.tr": true, "com.cn": true, "com.sg": true,
+	"co.za": true, "co.jp": true, "co.kr": true, "co.il": true, "com.ua": true,
+}
+
+// namecheapProvider updates a host through the Namecheap dynamic DNS
+// endpoint. It answers HTTP 200 whatever happened, the outcome is in the
+// ErrCount and errors of the XML document. Only IPv4 is supported.
+type namecheapProvider struct {
+	server   *url.URL
+	password string
+	domain   string
+}
+
+type namecheapResponse struct {
+	XMLName  xml.Name `xml:"interface-response"`
+	IP       string   `xml:"IP"`
+	ErrCount int      `xml:"ErrCount"`
+	Errors   struct {
+		Errs []string `xml:",any"`
+	} `xml:"errors"`
+	Responses []struct {
+		Number string `xml:"ResponseNumber"`
+		String string `xml:"ResponseString"`
+	} `xml:"responses>response"`
+	Done bool `xml:"Done"`
+}
+
+func newNamecheapProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &namecheapProvider{
+		password: entry.Get("dd-pass").String(),
+		domain:   strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+	}
+	if p.password == "" {
+		return nil, fmt.Errorf("namecheap provider of %s needs dd-pass, the dynamic DNS password of the domain", username)
+	}
+	server := entry.Get("server").String()
+	if server == "" {
+		server = defaultNamecheapServer
+	}
+	var err error
+	if p.server, err = url.Parse(server); err != nil || p.server.Host == "" {
+		return nil, fmt.Errorf("invalid server %q of %s", server, username)
+	}
+	registerSecrets(p.password)
+	return p, nil
+}
+
+// splitHost splits host into the record name Namecheap expects ("@" for the
+// apex) and the registered domain
+func (p *namecheapProvider) splitHost(host string) (string, string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	domain := p.domain
+	if domain == "" {
+		labels := strings.Split(host, ".")
+		if len(labels) < 2 {
+			return "", "", false
+		}
+		size := 2
+		if namecheapSecondLevelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+			size = 3
+		}
+		if len(labels) < size {
+			return "", "", false
+		}
+		domain = strings.Join(labels[len(labels)-size:], ".")
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", "", false
+	}
+	return relativeRecordName(host, domain), domain, true
+}
+
+func (p *namecheapProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	name, domain, ok := p.splitHost(req.Host)
+	if !ok {
+		return &UpdateResult{Code: ResultNotFQDN, Body: fmt.Sprintf("cannot find the namecheap domain of %s", req.Host)}, nil
+	}
+	ip := req.IPv4()
+	if ip == "" {
+		return &UpdateResult{Code: ResultServerError, Body: "namecheap dynamic DNS only updates IPv4 addresses"}, nil
+	}
+
+	target := *p.server
+	target.RawQuery = url.Values{"host": {name}, "domain": {domain}, "password": {p.password}, "ip": {ip}}.Encode()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building namecheap request: %v", err)
+	}
+
+	getLogger().Debugf("Calling namecheap %s", redact(target.String()))
+	resp, body, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling namecheap: %v", err)
+	}
+	result := &UpdateResult{StatusCode: resp.StatusCode, Body: string(body)}
+	if resp.StatusCode != http.StatusOK {
+		result.Code = httpStatusResultCode(resp.StatusCode)
+		return result, nil
+	}
+	var answer namecheapResponse
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	// the document declares utf-16 but is sent as utf-8
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := decoder.Decode(&answer); err != nil {
+		result.Code = ResultServerError
+		result.Body = fmt.Sprintf("invalid namecheap answer: %v", err)
+		return result, nil
+	}
+	result.Code, result.Body = answer.result()
+	return result, nil
+}
+
+// result reads the code and a one line summary from the answer
+func (a *namecheapResponse) result() (ResultCode, string) {
+	if a.ErrCount == 0 && len(a.Errors.Errs) == 0 {
+		if !a.Done {
+			return ResultServerError, "namecheap did not complete the update"
+		}
+		return ResultGood, "good " + a.IP
+	}
+	messages := a.Errors.Errs
+	code := ResultServerError
+	for _, response := range a.Responses {
+		if response.Number == namecheapInvalidPassword {
+			code = ResultBadAuth
+		}
+	}
+	for _, message := range messages {
+		lower := strings.ToLower(message)
+		switch {
+		case strings.Contains(lower, "password"):
+			code = ResultBadAuth
+		case code != ResultBadAuth && (strings.Contains(lower, "not found") || strings.Contains(lower, "domain name not")):
+			code = ResultNoHost
+		}
+	}
+	return code, strings.Join(messages, "; ")
+}
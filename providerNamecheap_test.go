@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestNamecheapSplitHost(t *testing.T) {
+	testCases := map[string][2]string{
+		"example.com":            {"@", "example.com"},
+		"home.example.com":       {"home", "example.com"},
+		"a.b.example.com":        {"a.b", "example.com"},
+		"home.example.co.uk":     {"home", "example.co.uk"},
+		"example.co.uk":          {"@", "example.co.uk"},
+		"Home.Example.com.":      {"home", "example.com"},
+		"home.lab.example.co.nz": {"home.lab", "example.co.nz"},
+	}
+	p := &namecheapProvider{}
+	for host, expected := range testCases {
+		name, domain, ok := p.splitHost(host)
+		if !ok || name != expected[0] || domain != expected[1] {
+			t.Errorf("%s expected %v but got %s %s %v", host, expected, name, domain, ok)
+		}
+	}
+
+	p = &namecheapProvider{domain: "example.pvt.k12.ma.us"}
+	if name, domain, ok := p.splitHost("home.example.pvt.k12.ma.us"); !ok || name != "home" || domain != "example.pvt.k12.ma.us" {
+		t.Errorf("configured domain not used: %s %s %v", name, domain, ok)
+	}
+	if _, _, ok := p.splitHost("home.example.com"); ok {
+		t.Errorf("host outside the configured domain was accepted")
+	}
+}
+
+func TestNamecheapProvider(t *testing.T) {
+	answers := map[string]string{
+		"good": `<?xml version="1.0" encoding="utf-16"?>
+<interface-response><Command>SETDNSHOST</Command><Language>eng</Language><IP>203.0.113.7</IP>
+<ErrCount>0</ErrCount><errors /><ResponseCount>0</ResponseCount><responses /><Done>true</Done></interface-response>`,
+		"wrong": `<?xml version="1.0" encoding="utf-16"?>
+<interface-response><Command>SETDNSHOST</Command><Language>eng</Language><ErrCount>1</ErrCount>
+<errors><Err1>Passwords do not match</Err1></errors><ResponseCount>1</ResponseCount><responses><response>
+<ResponseNumber>304156</ResponseNumber><Description>Validation error; invalid ; password</Description></response></responses>
+<Done>true</Done></interface-response>`,
+		"norecord": `<?xml version="1.0" encoding="utf-16"?>
+<interface-response><Command>SETDNSHOST</Command><Language>eng</Language><ErrCount>1</ErrCount>
+<errors><Err1>No Records updated. A record not Found;</Err1></errors><ResponseCount>1</ResponseCount><responses><response>
+<ResponseNumber>380091</ResponseNumber><ResponseString>No updates; A record not Found;</ResponseString></response></responses>
+<Done>true</Done></interface-response>`,
+		"garbage": `<html>oops</html>`,
+	}
+	queries := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries[r.URL.Query().Get("password")] = r.URL.RawQuery
+		_, _ = w.Write([]byte(answers[r.URL.Query().Get("password")]))
+	}))
+	defer server.Close()
+
+	testCases := map[string]ResultCode{
+		"good":     ResultGood,
+		"wrong":    ResultBadAuth,
+		"norecord": ResultNoHost,
+		"garbage":  ResultServerError,
+	}
+	for password, expected := range testCases {
+		provider, err := newNamecheapProvider("user", gjson.Parse(`{"dd-pass": "`+password+`", "server": "`+server.URL+`/update"}`))
+		if err != nil {
+			t.Fatalf("newNamecheapProvider failed: %v", err)
+		}
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{
+			Host: "home.example.co.uk",
+			IPs:  []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("203.0.113.7")},
+		})
+		if err != nil || result.Code != expected {
+			t.Errorf("%s expected %s but got %v, %v", password, expected, result, err)
+		}
+		if query := "domain=example.co.uk&host=home&ip=203.0.113.7&password=" + password; queries[password] != query {
+			t.Errorf("%s expected query %s but got %s", password, query, queries[password])
+		}
+	}
+
+	provider, _ := newNamecheapProvider("user", gjson.Parse(`{"dd-pass": "good", "server": "`+server.URL+`/update"}`))
+	result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("2001:db8::1")}})
+	if err != nil || result.Success() {
+		t.Errorf("IPv6 only update expected to fail but got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerOVH = "ovh"
+
+	defaultOVHEndpoint = "ovh-eu"
+)
+
+// ovhEndpoints are the API roots of the OVHcloud regions
+var ovhEndpoints = map[string]string{
+	"ovh-eu":        "https://eu.api.ovh.com/1.0",
+	"ovh-ca":        "https://ca.api.ovh.com/1.0",
+	"ovh-us":        "https://api.us.ovhcloud.com/1.0",
+	"kimsufi-eu":    "https://eu.api.kimsufi.com/1.0",
+	"kimsufi-ca":    "https://ca.api.kimsufi.com/1.0",
+	"soyoustart-eu": "https://eu.api.soyoustart.com/1.0",
+	"soyoustart-ca": "https://ca.api.soyoustart.com/1.0",
+}
+
+// ovhProvider updates the A and AAAA records of a host in an OVH DNS zone
+// and refreshes the zone to apply them. Requests are signed with the
+// application secret and consumer key; the timestamp of the signature comes
+// from the clock of the API, read once with /auth/time, as requests more
+// than a few seconds off are refused.
+type ovhProvider struct {
+	api         string
+	appKey      string
+	appSecret   string
+	consumerKey string
+	zone        string
+	ttl         int64
+
+	mu        sync.Mutex
+	timeDelta *time.Duration
+}
+
+type ovhRecord struct {
+	ID        int64  `json:"id,omitempty"`
+	FieldType string `json:"fieldType,omitempty"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int64  `json:"ttl"`
+}
+
+func newOVHProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &ovhProvider{
+		api:         entry.Get("endpoint").String(),
+		appKey:      entry.Get("application-key").String(),
+		appSecret:   entry.Get("application-secret").String(),
+		consumerKey: entry.Get("consumer-key").String(),
+		zone:        strings.TrimSuffix(strings.ToLower(entry.Get("zone").String()), "."),
+		ttl:         entry.Get("ttl").Int(),
+	}
+	if p.api == "" {
+		p.api = defaultOVHEndpoint
+	}
+	if api, ok := ovhEndpoints[p.api]; ok {
+		p.api = api
+	} else if api, err := url.Parse(p.api); err != nil || api.Host == "" {
+		return nil, fmt.Errorf("unknown endpoint %q of %s", p.api, username)
+	}
+	p.api = strings.TrimSuffix(p.api, "/")
+	if p.appKey == "" || p.appSecret == "" || p.consumerKey == "" {
+		return nil, fmt.Errorf("ovh provider of %s needs application-key, application-secret and consumer-key", username)
+	}
+	if p.ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl of %s", username)
+	}
+	registerSecrets(p.appSecret, p.consumerKey)
+	return p, nil
+}
+
+func (p *ovhProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zone, err := p.zoneFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	subDomain := relativeRecordName(req.Host, zone)
+	if subDomain == "@" {
+		subDomain = ""
+	}
+
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, zone, subDomain, record)
+		if err != nil {
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	if code == ResultGood {
+		if err := p.do(ctx, http.MethodPost, "/domain/zone/"+url.PathEscape(zone)+"/refresh", nil, nil); err != nil {
+			return resultFromError(err)
+		}
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// upsert points the record of subDomain to the address, keeping its TTL, and
+// reports whether anything changed. Extra records of the type are removed.
+func (p *ovhProvider) upsert(ctx context.Context, zone, subDomain string, address addressRecord) (bool, error) {
+	recordsPath := "/domain/zone/" + url.PathEscape(zone) + "/record"
+	var ids []int64
+	query := url.Values{"fieldType": {address.Type}, "subDomain": {subDomain}}
+	if err := p.do(ctx, http.MethodGet, recordsPath+"?"+query.Encode(), nil, &ids); err != nil {
+		return false, err
+	}
+	if len(ids) == 0 {
+		record := ovhRecord{FieldType: address.Type, SubDomain: subDomain, Target: address.IP, TTL: p.ttl}
+		return true, p.do(ctx, http.MethodPost, recordsPath, record, nil)
+	}
+
+	changed := false
+	for _, id := range ids[1:] {
+		if err := p.do(ctx, http.MethodDelete, fmt.Sprintf("%s/%d", recordsPath, id), nil, nil); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	var current ovhRecord
+	recordPath := fmt.Sprintf("%s/%d", recordsPath, ids[0])
+	if err := p.do(ctx, http.MethodGet, recordPath, nil, &current); err != nil {
+		return false, err
+	}
+	if current.Target == address.IP && (p.ttl == 0 || current.TTL == p.ttl) {
+		return changed, nil
+	}
+	record := ovhRecord{SubDomain: subDomain, Target: address.IP, TTL: current.TTL}
+	if p.ttl > 0 {
+		record.TTL = p.ttl
+	}
+	return true, p.do(ctx, http.MethodPut, recordPath, record, nil)
+}
+
+// zoneFor returns the configured zone, or the longest zone of the account host is in
+func (p *ovhProvider) zoneFor(ctx context.Context, host string) (string, error) {
+	if p.zone != "" {
+		return p.zone, nil
+	}
+	key := "ovh/zone/" + host
+	var zone string
+	if getState().Get(key, &zone) {
+		return zone, nil
+	}
+	var zones []string
+	if err := p.do(ctx, http.MethodGet, "/domain/zone", nil, &zones); err != nil {
+		return "", err
+	}
+	for _, candidate := range zoneCandidates(host) {
+		for _, name := range zones {
+			if strings.EqualFold(name, candidate) {
+				getState().Set(key, candidate, 24*time.Hour)
+				return candidate, nil
+			}
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no OVH zone found for " + host}
+}
+
+// now returns the time of the API clock
+func (p *ovhProvider) now(ctx context.Context) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timeDelta == nil {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.api+"/auth/time", nil)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error building OVH request: %v", err)
+		}
+		resp, data, err := doProviderRequest(httpReq)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading the OVH API time: %v", err)
+		}
+		serverTime, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if resp.StatusCode != http.StatusOK || err != nil {
+			return time.Time{}, fmt.Errorf("invalid OVH API time %q", data)
+		}
+		delta := time.Until(time.Unix(serverTime, 0))
+		p.timeDelta = &delta
+	}
+	return time.Now().Add(*p.timeDelta), nil
+}
+
+// do sends a signed request to the API and decodes the JSON answer into out
+func (p *ovhProvider) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	now, err := p.now(ctx)
+	if err != nil {
+		return err
+	}
+	target := p.api + path
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := sha1.Sum([]byte(strings.Join([]string{p.appSecret, p.consumerKey, method, target, string(body), timestamp}, "+")))
+	call := jsonCall{provider: "OVH", method: method, url: target, header: http.Header{
+		"X-Ovh-Application": {p.appKey},
+		"X-Ovh-Consumer":    {p.consumerKey},
+		"X-Ovh-Timestamp":   {timestamp},
+		"X-Ovh-Signature":   {"$1$" + hex.EncodeToString(signature[:])},
+		"Accept":            {"application/json"},
+	}}
+	if in != nil {
+		// the signed body is sent as it is
+		call.in = json.RawMessage(body)
+	}
+
+	data, err := doJSONCall(ctx, nil, call, func(apiErr *providerAPIError, data []byte) {
+		if message := gjson.GetBytes(data, "message").String(); message != "" {
+			apiErr.Message = message
+		}
+		switch gjson.GetBytes(data, "errorCode").String() {
+		case "INVALID_SIGNATURE", "INVALID_CREDENTIAL", "NOT_CREDENTIAL", "NOT_GRANTED_CALL":
+			apiErr.Code = ResultBadAuth
+		case "QUERY_TIME_OUT_OF_BOUNDS":
+			// the clock is read again on the next update
+			p.mu.Lock()
+			p.timeDelta = nil
+			p.mu.Unlock()
+			apiErr.Code = ResultServerError
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid OVH answer: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOVHProvider(t *testing.T) {
+	// the API clock is 10 minutes ahead, requests must be signed with its time
+	offset := 10 * time.Minute
+	var calls []string
+	var api *httptest.Server
+	api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1.0/auth/time" {
+			_, _ = fmt.Fprint(w, time.Now().Add(offset).Unix())
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Ovh-Timestamp"), 10, 64)
+		sum := sha1.Sum([]byte(strings.Join([]string{"secret", "consumer", r.Method, api.URL + r.URL.RequestURI(), string(body), r.Header.Get("X-Ovh-Timestamp")}, "+")))
+		switch {
+		case r.Header.Get("X-Ovh-Signature") != "$1$"+hex.EncodeToString(sum[:]):
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errorCode": "INVALID_SIGNATURE", "httpCode": "400 Bad Request", "message": "Invalid signature"}`))
+			return
+		case time.Since(time.Unix(timestamp, 0).Add(-offset)).Abs() > 5*time.Second:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errorCode": "QUERY_TIME_OUT_OF_BOUNDS", "message": "Query out of time"}`))
+			return
+		}
+		calls = append(calls, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /1.0/domain/zone":
+			_, _ = w.Write([]byte(`["example.com", "example.org"]`))
+		case "GET /1.0/domain/zone/example.com/record?fieldType=A&subDomain=home":
+			_, _ = w.Write([]byte(`[11, 12]`))
+		case "GET /1.0/domain/zone/example.com/record?fieldType=AAAA&subDomain=home":
+			_, _ = w.Write([]byte(`[]`))
+		case "GET /1.0/domain/zone/example.com/record/11":
+			_, _ = w.Write([]byte(`{"id": 11, "fieldType": "A", "subDomain": "home", "target": "198.51.100.1", "ttl": 60, "zone": "example.com"}`))
+		case "GET /1.0/domain/zone/example.com/record?fieldType=A&subDomain=":
+			_, _ = w.Write([]byte(`[21]`))
+		case "GET /1.0/domain/zone/example.com/record/21":
+			_, _ = w.Write([]byte(`{"id": 21, "fieldType": "A", "subDomain": "", "target": "203.0.113.7", "ttl": 0, "zone": "example.com"}`))
+		case "GET /1.0/domain/zone/example.com/record?fieldType=A&subDomain=gone":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"class": "Client::NotFound", "message": "This service does not exist"}`))
+		default:
+			_, _ = w.Write([]byte(`null`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(secret string) Provider {
+		return newTestProvider(t, newOVHProvider, `{"endpoint": "`+api.URL+`/1.0", "application-key": "app",
+			"application-secret": "`+secret+`", "consumer-key": "consumer"}`)
+	}
+
+	provider := fromEntry("secret")
+	result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		"GET /1.0/domain/zone",
+		"GET /1.0/domain/zone/example.com/record?fieldType=A&subDomain=home",
+		"DELETE /1.0/domain/zone/example.com/record/12",
+		"GET /1.0/domain/zone/example.com/record/11",
+		`PUT /1.0/domain/zone/example.com/record/11 {"subDomain":"home","target":"203.0.113.7","ttl":60}`,
+		"GET /1.0/domain/zone/example.com/record?fieldType=AAAA&subDomain=home",
+		`POST /1.0/domain/zone/example.com/record {"fieldType":"AAAA","subDomain":"home","target":"2001:db8::1","ttl":0}`,
+		"POST /1.0/domain/zone/example.com/refresh",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected API calls:\n%s", strings.Join(calls, "\n"))
+	}
+
+	testCases := map[string]struct {
+		secret string
+		host   string
+		code   ResultCode
+	}{
+		"apex unchanged": {"secret", "example.com", ResultNoChange},
+		"bad signature":  {"wrong", "home.example.com", ResultBadAuth},
+		"no zone":        {"secret", "home.example.net", ResultNoHost},
+		"not found":      {"secret", "gone.example.com", ResultNoHost},
+	}
+	for name, testCase := range testCases {
+		calls = nil
+		result, err := fromEntry(testCase.secret).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+		if testCase.code == ResultNoChange && len(calls) > 0 && strings.HasSuffix(calls[len(calls)-1], "/refresh") {
+			t.Errorf("%s refreshed the zone without a change", name)
+		}
+	}
+}
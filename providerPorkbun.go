@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerPorkbun = "porkbun"
+
+	defaultPorkbunAPI = "https://api.porkbun.com/api/json/v3"
+	// Porkbun refuses TTLs under 600 seconds
+	porkbunMinimumTTL = 600
+)
+
+// porkbunProvider updates the A and AAAA records of a host in Porkbun DNS.
+// The API key and secret are sent in the JSON body of every call, which is
+// always a POST. The domain needs API access enabled in the Porkbun panel.
+type porkbunProvider struct {
+	api       string
+	apiKey    string
+	secretKey string
+	domain    string
+	ttl       int64
+	limit     *providerRateLimit
+}
+
+type porkbunRecord struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     string `json:"ttl"`
+}
+
+func newPorkbunProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &porkbunProvider{
+		api:       strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		apiKey:    entry.Get("api-key").String(),
+		secretKey: entry.Get("secret-api-key").String(),
+		domain:    strings.TrimSuffix(strings.ToLower(entry.Get("domain").String()), "."),
+		ttl:       entry.Get("ttl").Int(),
+		limit:     &providerRateLimit{name: "Porkbun"},
+	}
+	if p.api == "" {
+		p.api = defaultPorkbunAPI
+	}
+	if p.apiKey == "" || p.secretKey == "" {
+		return nil, fmt.Errorf("porkbun provider of %s needs api-key and secret-api-key", username)
+	}
+	if p.ttl != 0 && p.ttl < porkbunMinimumTTL {
+		return nil, fmt.Errorf("invalid ttl of %s, porkbun needs at least %d", username, porkbunMinimumTTL)
+	}
+	registerSecrets(p.apiKey, p.secretKey)
+	return p, nil
+}
+
+func (p *porkbunProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	domain, err := p.domainFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	// the apex has an empty subdomain
+	subdomain := relativeRecordName(req.Host, domain)
+	if subdomain == "@" {
+		subdomain = ""
+	}
+
+	code := ResultNoChange
+	var lines []string
+	for _, record := range req.AddressRecords() {
+		changed, err := p.upsert(ctx, domain, subdomain, record)
+		if err != nil {
+			return resultFromError(err)
+		}
+		outcome := "unchanged"
+		if changed {
+			code, outcome = ResultGood, "updated"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", record.Type, req.Host, record.IP, outcome))
+	}
+	return &UpdateResult{Code: code, StatusCode: http.StatusOK, Body: strings.Join(lines, "\n")}, nil
+}
+
+// upsert sets the record of subdomain to the address, creating it if needed,
+// and reports whether anything changed. Existing records keep their TTL.
+func (p *porkbunProvider) upsert(ctx context.Context, domain, subdomain string, address addressRecord) (bool, error) {
+	namePath := "/" + url.PathEscape(domain) + "/" + address.Type + "/" + url.PathEscape(subdomain)
+	data, err := p.do(ctx, "/dns/retrieveByNameType"+namePath, nil)
+	if err != nil {
+		return false, err
+	}
+	var answer struct {
+		Records []porkbunRecord `json:"records"`
+	}
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return false, fmt.Errorf("invalid Porkbun answer: %v", err)
+	}
+
+	ttl := fmt.Sprint(p.ttl)
+	if len(answer.Records) == 0 {
+		if p.ttl == 0 {
+			ttl = fmt.Sprint(porkbunMinimumTTL)
+		}
+		change := map[string]string{"name": subdomain, "type": address.Type, "content": address.IP, "ttl": ttl}
+		_, err := p.do(ctx, "/dns/create/"+url.PathEscape(domain), change)
+		return err == nil, err
+	}
+	current := answer.Records[0]
+	if p.ttl == 0 {
+		ttl = current.TTL
+	}
+	if len(answer.Records) == 1 && current.Content == address.IP && current.TTL == ttl {
+		return false, nil
+	}
+	// editByNameType sets every record of the name and type
+	_, err = p.do(ctx, "/dns/editByNameType"+namePath, map[string]string{"content": address.IP, "ttl": ttl})
+	return err == nil, err
+}
+
+// domainFor returns the configured domain, or the longest domain of the account host is in
+func (p *porkbunProvider) domainFor(ctx context.Context, host string) (string, error) {
+	if p.domain != "" {
+		return p.domain, nil
+	}
+	key := "porkbun/domain/" + host
+	var domain string
+	if getState().Get(key, &domain) {
+		return domain, nil
+	}
+	owned := map[string]bool{}
+	// listAll answers up to 1000 domains from start
+	for start := 0; ; start += 1000 {
+		data, err := p.do(ctx, "/domain/listAll", map[string]string{"start": fmt.Sprint(start)})
+		if err != nil {
+			return "", err
+		}
+		domains := gjson.GetBytes(data, "domains").Array()
+		for _, d := range domains {
+			owned[strings.ToLower(d.Get("domain").String())] = true
+		}
+		if len(domains) < 1000 {
+			break
+		}
+	}
+	for _, name := range zoneCandidates(host) {
+		if owned[name] {
+			getState().Set(key, name, 24*time.Hour)
+			return name, nil
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Porkbun domain found for " + host}
+}
+
+// do posts the fields with the keys to the API and returns a successful answer
+func (p *porkbunProvider) do(ctx context.Context, path string, fields map[string]string) ([]byte, error) {
+	body := map[string]string{"apikey": p.apiKey, "secretapikey": p.secretKey}
+	for key, value := range fields {
+		body[key] = value
+	}
+	call := jsonCall{provider: "Porkbun", method: http.MethodPost, url: p.api + path, in: body}
+	data, err := doJSONCall(ctx, p.limit, call, explainPorkbunError)
+	if err == nil && gjson.GetBytes(data, "status").String() != "SUCCESS" {
+		// Porkbun also refuses calls with a 200 answer
+		apiErr := &providerAPIError{Code: ResultServerError, StatusCode: http.StatusOK, Message: string(data)}
+		explainPorkbunError(apiErr, data)
+		return nil, apiErr
+	}
+	return data, err
+}
+
+// explainPorkbunError reads the message of a refused call and maps it to a result code
+func explainPorkbunError(apiErr *providerAPIError, data []byte) {
+	message := gjson.GetBytes(data, "message").String()
+	if message != "" {
+		apiErr.Message = message
+	}
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "api key") || strings.Contains(lower, "not opted in to api access"):
+		apiErr.Code = ResultBadAuth
+	case strings.Contains(lower, "invalid domain"):
+		apiErr.Code = ResultNoHost
+	case apiErr.StatusCode/100 == 2 || apiErr.StatusCode == http.StatusBadRequest:
+		apiErr.Code = ResultServerError
+	}
+}
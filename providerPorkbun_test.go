@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestPorkbunProvider(t *testing.T) {
+	var writes []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || gjson.GetBytes(body, "apikey").String() != "pk1_key" || gjson.GetBytes(body, "secretapikey").String() != "sk1_secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status": "ERROR", "message": "Invalid API key. (002)"}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/api/json/v3/domain/listAll":
+			_, _ = w.Write([]byte(`{"status": "SUCCESS", "domains": [{"domain": "example.com"}, {"domain": "example.net"}]}`))
+		case "/api/json/v3/dns/retrieveByNameType/example.com/A/home":
+			_, _ = w.Write([]byte(`{"status": "SUCCESS", "records": [{"id": "1", "name": "home.example.com", "type": "A", "content": "198.51.100.1", "ttl": "1200", "prio": "0", "notes": ""}]}`))
+		case "/api/json/v3/dns/retrieveByNameType/example.com/A/":
+			_, _ = w.Write([]byte(`{"status": "SUCCESS", "records": [{"id": "2", "name": "example.com", "type": "A", "content": "203.0.113.7", "ttl": "600"}]}`))
+		case "/api/json/v3/dns/retrieveByNameType/example.com/AAAA/home":
+			_, _ = w.Write([]byte(`{"status": "SUCCESS", "records": []}`))
+		case "/api/json/v3/dns/retrieveByNameType/example.net/A/home":
+			_, _ = w.Write([]byte(`{"status": "ERROR", "message": "Domain is not opted in to API access."}`))
+		case "/api/json/v3/dns/editByNameType/example.com/A/home", "/api/json/v3/dns/create/example.com":
+			fields := gjson.ParseBytes(body).Map()
+			delete(fields, "apikey")
+			delete(fields, "secretapikey")
+			var pairs []string
+			for _, key := range []string{"name", "type", "content", "ttl"} {
+				if value, ok := fields[key]; ok {
+					pairs = append(pairs, key+"="+value.String())
+				}
+			}
+			writes = append(writes, strings.TrimPrefix(r.URL.Path, "/api/json/v3/dns/")+" "+strings.Join(pairs, " "))
+			_, _ = w.Write([]byte(`{"status": "SUCCESS", "id": 3}`))
+		default:
+			_, _ = w.Write([]byte(`{"status": "ERROR", "message": "Invalid domain."}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	fromEntry := func(entry string) Provider {
+		return newTestProvider(t, newPorkbunProvider, `{"api-url": "`+api.URL+`/api/json/v3", "secret-api-key": "sk1_secret", `+entry+`}`)
+	}
+
+	result, err := fromEntry(`"api-key": "pk1_key"`).Update(localProviderContext(), &UpdateRequest{
+		Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")},
+	})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		"editByNameType/example.com/A/home content=203.0.113.7 ttl=1200",
+		"create/example.com name=home type=AAAA content=2001:db8::1 ttl=600",
+	}
+	if strings.Join(writes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected record writes:\n%s", strings.Join(writes, "\n"))
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		code  ResultCode
+	}{
+		"apex unchanged": {`"api-key": "pk1_key", "domain": "example.com"`, "example.com", ResultNoChange},
+		"bad key":        {`"api-key": "wrong"`, "home.example.org", ResultBadAuth},
+		"no api access":  {`"api-key": "pk1_key"`, "home.example.net", ResultBadAuth},
+		"no domain":      {`"api-key": "pk1_key"`, "home.example.org", ResultNoHost},
+		"invalid domain": {`"api-key": "pk1_key", "domain": "example.org"`, "home.example.org", ResultNoHost},
+	}
+	for name, testCase := range testCases {
+		result, err := fromEntry(testCase.entry).Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+
+	if _, err := newPorkbunProvider("user", gjson.Parse(`{"api-key": "k", "secret-api-key": "s", "ttl": 300}`)); err == nil {
+		t.Errorf("ttl under the Porkbun minimum was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerPowerDNS = "powerdns"
+
+	defaultPowerDNSServer = "localhost"
+	defaultPowerDNSTTL    = 300
+)
+
+// powerDNSProvider replaces the A and AAAA RRsets of a host through the
+// PowerDNS Authoritative HTTP API. Without a configured zone, the zone of
+// the host is the longest suffix match among the zones of the server.
+type powerDNSProvider struct {
+	api     string
+	apiKey  string
+	server  string
+	zone    string
+	ttl     int64
+	notify  bool
+	rectify bool
+}
+
+type powerDNSRRset struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int64            `json:"ttl"`
+	ChangeType string           `json:"changetype"`
+	Records    []powerDNSRecord `json:"records"`
+}
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+func newPowerDNSProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &powerDNSProvider{
+		api:     strings.TrimSuffix(entry.Get("api-url").String(), "/"),
+		apiKey:  entry.Get("api-key").String(),
+		server:  entry.Get("server").String(),
+		ttl:     defaultPowerDNSTTL,
+		notify:  entry.Get("notify").Bool(),
+		rectify: entry.Get("rectify").Bool(),
+	}
+	if p.api == "" || p.apiKey == "" {
+		return nil, fmt.Errorf("powerdns provider of %s needs api-url and api-key", username)
+	}
+	if api, err := url.Parse(p.api); err != nil || api.Host == "" {
+		return nil, fmt.Errorf("invalid api-url %q of %s", p.api, username)
+	}
+	// the API lives under /api/v1, accept the server root as well
+	if !strings.HasSuffix(p.api, "/api/v1") {
+		p.api += "/api/v1"
+	}
+	if p.server == "" {
+		p.server = defaultPowerDNSServer
+	}
+	if zone := entry.Get("zone").String(); zone != "" {
+		p.zone = canonicalDNSName(zone)
+	}
+	if ttl := entry.Get("ttl"); ttl.Exists() {
+		if ttl.Int() <= 0 {
+			return nil, fmt.Errorf("invalid ttl of %s", username)
+		}
+		p.ttl = ttl.Int()
+	}
+	registerSecrets(p.apiKey)
+	return p, nil
+}
+
+func (p *powerDNSProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zone, err := p.zoneFor(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	var change struct {
+		RRsets []powerDNSRRset `json:"rrsets"`
+	}
+	for _, record := range req.AddressRecords() {
+		change.RRsets = append(change.RRsets, powerDNSRRset{
+			Name:       canonicalDNSName(req.Host),
+			Type:       record.Type,
+			TTL:        p.ttl,
+			ChangeType: "REPLACE",
+			Records:    []powerDNSRecord{{Content: record.IP}},
+		})
+	}
+	zonePath := "/servers/" + url.PathEscape(p.server) + "/zones/" + url.PathEscape(zone)
+	if _, err := p.do(ctx, http.MethodPatch, zonePath, change); err != nil {
+		return resultFromError(err)
+	}
+
+	// the change is applied, failures of the follow-up calls are only logged
+	if p.rectify {
+		if _, err := p.do(ctx, http.MethodPut, zonePath+"/rectify", nil); err != nil {
+			getLogger().Warnf("Error rectifying PowerDNS zone %s: %v", zone, err)
+		}
+	}
+	if p.notify {
+		if _, err := p.do(ctx, http.MethodPut, zonePath+"/notify", nil); err != nil {
+			getLogger().Warnf("Error notifying the secondaries of PowerDNS zone %s: %v", zone, err)
+		}
+	}
+	return &UpdateResult{
+		Code:       ResultGood,
+		StatusCode: http.StatusOK,
+		Body:       fmt.Sprintf("%s %s", req.Host, strings.Join(req.IPStrings(), ",")),
+	}, nil
+}
+
+// zoneFor returns the configured zone, or the longest zone of the server host is in
+func (p *powerDNSProvider) zoneFor(ctx context.Context, host string) (string, error) {
+	if p.zone != "" {
+		return p.zone, nil
+	}
+	name := canonicalDNSName(host)
+	key := "powerdns/zone/" + p.api + "/" + name
+	var zone string
+	if getState().Get(key, &zone) {
+		return zone, nil
+	}
+	data, err := p.do(ctx, http.MethodGet, "/servers/"+url.PathEscape(p.server)+"/zones", nil)
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range gjson.ParseBytes(data).Array() {
+		candidateName := canonicalDNSName(candidate.Get("name").String())
+		if (name == candidateName || strings.HasSuffix(name, "."+candidateName)) && len(candidateName) > len(zone) {
+			zone = candidateName
+		}
+	}
+	if zone == "" {
+		return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no PowerDNS zone found for " + host}
+	}
+	getState().Set(key, zone, 24*time.Hour)
+	return zone, nil
+}
+
+// do calls the API with the X-API-Key header
+func (p *powerDNSProvider) do(ctx context.Context, method, path string, in interface{}) ([]byte, error) {
+	call := jsonCall{provider: "PowerDNS", method: method, url: p.api + path, in: in,
+		header: http.Header{"X-Api-Key": {p.apiKey}, "Accept": {"application/json"}}}
+	return doJSONCall(ctx, nil, call, func(apiErr *providerAPIError, data []byte) {
+		if message := gjson.GetBytes(data, "error").String(); message != "" {
+			apiErr.Message = message
+		}
+		// 422 is a change PowerDNS refused, e.g. a name outside the zone
+		if apiErr.StatusCode == http.StatusUnprocessableEntity && strings.Contains(apiErr.Message, "out of zone") {
+			apiErr.Code = ResultNoHost
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestPowerDNSProvider(t *testing.T) {
+	var calls []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("Unauthorized"))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		calls = append(calls, strings.TrimSpace(r.Method+" "+r.URL.EscapedPath()+" "+string(body)))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/servers/localhost/zones":
+			_, _ = w.Write([]byte(`[{"id": "example.com.", "name": "example.com."}, {"id": "lab.example.com.", "name": "lab.example.com."}, {"id": "ample.com.", "name": "ample.com."}]`))
+		case r.Method == http.MethodPatch && strings.Contains(string(body), "203.0.113.255"):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error": "Record home.lab.example.com./A '203.0.113.255': Not in expected format"}`))
+		case r.Method == http.MethodPatch:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/rectify"):
+			_, _ = w.Write([]byte(`{"result": "Rectified"}`))
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/notify"):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error": "Domain 'lab.example.com.' is not a master domain"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": "Not Found"}`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	provider, err := newPowerDNSProvider("user", gjson.Parse(`{"api-url": "`+api.URL+`", "api-key": "secret", "ttl": 60, "rectify": true, "notify": true}`))
+	if err != nil {
+		t.Fatalf("newPowerDNSProvider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.lab.example.com", IPs: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("203.0.113.7")}}
+	result, err := provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	expected := []string{
+		"GET /api/v1/servers/localhost/zones",
+		`PATCH /api/v1/servers/localhost/zones/lab.example.com. {"rrsets":[` +
+			`{"name":"home.lab.example.com.","type":"A","ttl":60,"changetype":"REPLACE","records":[{"content":"203.0.113.7","disabled":false}]},` +
+			`{"name":"home.lab.example.com.","type":"AAAA","ttl":60,"changetype":"REPLACE","records":[{"content":"2001:db8::1","disabled":false}]}]}`,
+		"PUT /api/v1/servers/localhost/zones/lab.example.com./rectify",
+		"PUT /api/v1/servers/localhost/zones/lab.example.com./notify",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected API calls:\n%s", strings.Join(calls, "\n"))
+	}
+
+	// the zone is cached
+	calls = nil
+	if result, err := provider.Update(localProviderContext(), update); err != nil || result.Code != ResultGood || strings.HasPrefix(calls[0], "GET") {
+		t.Errorf("second update expected %s without a zone lookup but got %v, %v, %v", ResultGood, result, err, calls)
+	}
+
+	testCases := map[string]struct {
+		entry string
+		host  string
+		ip    string
+		code  ResultCode
+	}{
+		"bad key":        {`"api-key": "wrong"`, "home.example.com", "203.0.113.7", ResultBadAuth},
+		"no zone":        {`"api-key": "secret"`, "home.example.org", "203.0.113.7", ResultNoHost},
+		"refused change": {`"api-key": "secret", "zone": "example.com"`, "home.example.com", "203.0.113.255", ResultServerError},
+		"apex":           {`"api-key": "secret", "zone": "example.com."`, "example.com", "203.0.113.7", ResultGood},
+	}
+	for name, testCase := range testCases {
+		provider, err := newPowerDNSProvider("user", gjson.Parse(`{"api-url": "`+api.URL+`/api/v1/", `+testCase.entry+`}`))
+		if err != nil {
+			t.Fatalf("newPowerDNSProvider failed: %v", err)
+		}
+		result, err := provider.Update(localProviderContext(), &UpdateRequest{Host: testCase.host, IPs: []net.IP{net.ParseIP(testCase.ip)}})
+		if err != nil || result.Code != testCase.code {
+			t.Errorf("%s expected %s but got %v, %v", name, testCase.code, result, err)
+		}
+	}
+}
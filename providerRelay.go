@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// relayHopsHeader counts the ddns-proxy instances a relayed update went through
+	relayHopsHeader = "X-DDNS-Proxy-Hops"
+	// relayViaHeader lists the instance IDs of those instances, to detect loops
+	relayViaHeader = "X-DDNS-Proxy-Via"
+)
+
+// relayProvider forwards the update to a downstream ddns-proxy instance,
+// which applies it with its own provider and credential entry.
+type relayProvider struct {
+	endpoint *url.URL
+	user     string
+	pass     string
+}
+
+func newRelayProvider(username string, entry gjson.Result) (Provider, error) {
+	endpoint, err := url.Parse(entry.Get("relay-url").String())
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("relay provider of %s needs a valid relay-url", username)
+	}
+	if endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("relay-url of %s must use https", username)
+	}
+	p := &relayProvider{
+		endpoint: endpoint,
+		user:     entry.Get("relay-user").String(),
+		pass:     entry.Get("relay-pass").String(),
+	}
+	if p.user == "" || p.pass == "" {
+		return nil, fmt.Errorf("relay provider of %s needs relay-user and relay-pass", username)
+	}
+	registerSecrets(p.pass)
+	return p, nil
+}
+
+func (p *relayProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	hops := req.Hops + 1
+	if hops > cfg.RelayMaxHops {
+		return &UpdateResult{
+			Code:       ResultServerError,
+			StatusCode: http.StatusLoopDetected,
+			Body:       fmt.Sprintf("relay chain longer than %d hops", cfg.RelayMaxHops),
+		}, nil
+	}
+
+	target := *p.endpoint
+	query := target.Query()
+	query.Set("hostname", req.Host)
+	query.Set("ip", strings.Join(req.IPStrings(), ","))
+	if req.Force {
+		query.Set("force", "yes")
+	}
+	target.RawQuery = query.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building relay request: %v", err)
+	}
+	httpReq.SetBasicAuth(p.user, p.pass)
+	httpReq.Header.Set(relayHopsHeader, strconv.Itoa(hops))
+	httpReq.Header.Set(relayViaHeader, strings.Join(append(append([]string{}, req.Via...), cfg.InstanceID), ", "))
+
+	getLogger().Debugf("Relaying update of %s to %s (hop %d)", req.Host, p.endpoint.Host, hops)
+	resp, err := getUpstreamClient().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling relay: %v", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading relay response: %v", err)
+	}
+
+	// The downstream answer goes back to the client unchanged
+	return &UpdateResult{
+		Code:       relayResultCode(resp, string(body)),
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		Verbatim:   true,
+	}, nil
+}
+
+// relayResultCode reads the result code of a downstream instance, guessing it
+// from the response when the downstream does not send the result header.
+func relayResultCode(resp *http.Response, body string) ResultCode {
+	if code := resp.Header.Get(resultHeader); code != "" {
+		return ResultCode(code)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return ResultBadAuth
+	case resp.StatusCode != http.StatusOK:
+		return ResultServerError
+	case strings.HasPrefix(body, "nochn"):
+		return ResultNoChange
+	case strings.HasPrefix(body, "OK"):
+		return ResultGood
+	default:
+		return ResultServerError
+	}
+}
+
+// relayHopsFromRequest reads the hop headers of an incoming request and
+// refuses requests that went through too many instances or through this one.
+func relayHopsFromRequest(r *http.Request) (hops int, via []string, err error) {
+	if value := r.Header.Get(relayHopsHeader); value != "" {
+		hops, err = strconv.Atoi(value)
+		if err != nil || hops < 0 {
+			return 0, nil, fmt.Errorf("invalid %s header", relayHopsHeader)
+		}
+	}
+	for _, id := range strings.Split(r.Header.Get(relayViaHeader), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if id == cfg.InstanceID {
+			return 0, nil, fmt.Errorf("relay loop detected, request already went through %s", id)
+		}
+		via = append(via, id)
+	}
+	if hops > cfg.RelayMaxHops {
+		return 0, nil, fmt.Errorf("relay chain longer than %d hops", cfg.RelayMaxHops)
+	}
+	return hops, via, nil
+}
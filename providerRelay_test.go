@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func setupRelayTest(t *testing.T, handler http.HandlerFunc) (*httptest.Server, Provider) {
+	downstream := httptest.NewTLSServer(handler)
+	savedCfg, savedClient := cfg, upstreamClient
+	cfg = &ServerConfig{InstanceID: "edge", RelayMaxHops: 2}
+	upstreamClient = downstream.Client()
+	t.Cleanup(func() {
+		downstream.Close()
+		cfg, upstreamClient = savedCfg, savedClient
+	})
+
+	provider, err := newProvider("edge-user", gjson.Parse(`{
+		"provider": "relay",
+		"relay-url": "`+downstream.URL+`/update",
+		"relay-user": "next",
+		"relay-pass": "next-pass"
+	}`))
+	if err != nil {
+		t.Fatalf("newProvider failed: %v", err)
+	}
+	return downstream, provider
+}
+
+func TestRelayProviderForwardsUpdate(t *testing.T) {
+	var got *http.Request
+	_, provider := setupRelayTest(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set(resultHeader, string(ResultNoChange))
+		_, _ = w.Write([]byte("nochn : IP already is set 203.0.113.7,2001:db8::7"))
+	})
+
+	result, err := provider.Update(context.Background(), &UpdateRequest{
+		Host:  "home.example.com",
+		IPs:   []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::7")},
+		Force: true,
+		Hops:  1,
+		Via:   []string{"first"},
+	})
+	if err != nil {
+		t.Fatalf("relay update failed: %v", err)
+	}
+
+	if user, pass, _ := got.BasicAuth(); user != "next" || pass != "next-pass" {
+		t.Errorf("relay did not use its own credentials, got %s:%s", user, pass)
+	}
+	query := got.URL.Query()
+	if query.Get("hostname") != "home.example.com" || query.Get("ip") != "203.0.113.7,2001:db8::7" || query.Get("force") != "yes" {
+		t.Errorf("relay forwarded unexpected parameters: %s", got.URL.RawQuery)
+	}
+	if got.Header.Get(relayHopsHeader) != "2" || got.Header.Get(relayViaHeader) != "first, edge" {
+		t.Errorf("unexpected hop headers: %s=%s %s=%s", relayHopsHeader, got.Header.Get(relayHopsHeader), relayViaHeader, got.Header.Get(relayViaHeader))
+	}
+	if result.Code != ResultNoChange || !result.Verbatim || result.Body != "nochn : IP already is set 203.0.113.7,2001:db8::7" {
+		t.Errorf("downstream result was not passed back unchanged: %+v", result)
+	}
+}
+
+func TestRelayProviderMaxHops(t *testing.T) {
+	called := false
+	_, provider := setupRelayTest(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	result, err := provider.Update(context.Background(), &UpdateRequest{
+		Host: "home.example.com",
+		IPs:  []net.IP{net.ParseIP("203.0.113.7")},
+		Hops: 2,
+	})
+	if err != nil {
+		t.Fatalf("relay update failed: %v", err)
+	}
+	if called || result.StatusCode != http.StatusLoopDetected {
+		t.Errorf("relay forwarded an update beyond the hop limit: %+v", result)
+	}
+}
+
+func TestRelayHopsFromRequest(t *testing.T) {
+	savedCfg := cfg
+	cfg = &ServerConfig{InstanceID: "edge", RelayMaxHops: 2}
+	defer func() { cfg = savedCfg }()
+
+	testCases := []map[string]interface{}{
+		{"hops": "", "via": "", "valid": true},
+		{"hops": "1", "via": "first", "valid": true},
+		{"hops": "2", "via": "first, edge", "valid": false},
+		{"hops": "3", "via": "a, b, c", "valid": false},
+		{"hops": "x", "via": "", "valid": false},
+	}
+
+	for _, testCase := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(relayHopsHeader, testCase["hops"].(string))
+		r.Header.Set(relayViaHeader, testCase["via"].(string))
+		_, _, err := relayHopsFromRequest(r)
+		if (err == nil) != testCase["valid"].(bool) {
+			t.Errorf("hops=%q via=%q expected valid=%v but got error %v", testCase["hops"], testCase["via"], testCase["valid"], err)
+		}
+	}
+}
+
+func TestRequestedForce(t *testing.T) {
+	testCases := map[string]struct {
+		accept bool
+		via    []string
+		force  string
+		forced bool
+	}{
+		"relayed and accepted":     {accept: true, via: []string{"edge"}, force: "yes", forced: true},
+		"relayed without force":    {accept: true, via: []string{"edge"}, forced: false},
+		"not relayed":              {accept: true, force: "yes", forced: false},
+		"spoofed via not accepted": {accept: false, via: []string{"edge"}, force: "yes", forced: false},
+	}
+	for name, testCase := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/update?force="+testCase.force, nil)
+		if forced := requestedForce(r, &UserInfo{AcceptRelayForce: testCase.accept}, testCase.via); forced != testCase.forced {
+			t.Errorf("%s expected forced %v but got %v", name, testCase.forced, forced)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerRFC2136 = "rfc2136"
+
+	defaultRFC2136TTL     = 300
+	defaultRFC2136Timeout = 5 * time.Second
+
+	// prerequisites of the update (RFC 2136 section 2.4)
+	rfc2136PrereqNone        = "none"
+	rfc2136PrereqNameInUse   = "name-in-use"
+	rfc2136PrereqRRsetExists = "rrset-exists"
+)
+
+// rfc2136Provider sends DNS UPDATE messages (RFC 2136) signed with TSIG
+// straight to an authoritative server (BIND, Knot, PowerDNS, ...). For each
+// address family the RRset of the host is deleted and the new address added
+// in the same message, so the update is applied atomically.
+type rfc2136Provider struct {
+	server  dnsServer
+	zone    string
+	key     *tsigKey
+	ttl     uint32
+	prereq  string
+	timeout time.Duration
+}
+
+func newRFC2136Provider(username string, entry gjson.Result) (Provider, error) {
+	server, err := parseDNSServer(entry.Get("server").String())
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136 provider of %s: %v", username, err)
+	}
+	if server.transport != dnsTransportUDP && server.transport != dnsTransportTCP {
+		return nil, fmt.Errorf("rfc2136 server of %s must use udp or tcp", username)
+	}
+	p := &rfc2136Provider{
+		server:  server,
+		zone:    entry.Get("zone").String(),
+		ttl:     defaultRFC2136TTL,
+		prereq:  strings.ToLower(entry.Get("prerequisite").String()),
+		timeout: defaultRFC2136Timeout,
+	}
+	if p.zone == "" {
+		return nil, fmt.Errorf("rfc2136 provider of %s needs a zone", username)
+	}
+	p.zone = canonicalDNSName(p.zone)
+	if ttl := entry.Get("ttl"); ttl.Exists() {
+		if ttl.Int() < 0 || ttl.Int() > 0x7fffffff {
+			return nil, fmt.Errorf("invalid ttl of %s", username)
+		}
+		p.ttl = uint32(ttl.Int())
+	}
+	if timeout := entry.Get("timeout").String(); timeout != "" {
+		if p.timeout, err = time.ParseDuration(timeout); err != nil || p.timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout of %s", username)
+		}
+	}
+	switch p.prereq {
+	case "":
+		p.prereq = rfc2136PrereqNone
+	case rfc2136PrereqNone, rfc2136PrereqNameInUse, rfc2136PrereqRRsetExists:
+	default:
+		return nil, fmt.Errorf("unknown prerequisite %q of %s", p.prereq, username)
+	}
+	if name := entry.Get("tsig-name").String(); name != "" {
+		algorithm := entry.Get("tsig-algorithm").String()
+		if algorithm == "" {
+			algorithm = tsigHMACSHA256
+		}
+		secret := entry.Get("tsig-secret").String()
+		if p.key, err = newTSIGKey(name, algorithm, secret); err != nil {
+			return nil, fmt.Errorf("tsig key of %s: %v", username, err)
+		}
+		registerSecrets(secret)
+	}
+	return p, nil
+}
+
+// updateMessage builds the UPDATE for host: zone section, prerequisites and
+// for each address a delete of its RRset followed by the add
+func (p *rfc2136Provider) updateMessage(host string, records []addressRecord) (*dnsMessage, error) {
+	name := canonicalDNSName(host)
+	if name != p.zone && !strings.HasSuffix(name, "."+p.zone) {
+		return nil, &providerAPIError{Code: ResultNoHost, Message: fmt.Sprintf("%s is not in zone %s", host, p.zone)}
+	}
+	m := &dnsMessage{
+		ID:        newDNSID(),
+		Flags:     dnsOpcodeUpdate << 11,
+		Questions: []dnsQuestion{{Name: p.zone, Type: dnsTypeSOA, Class: dnsClassINET}},
+	}
+	if p.prereq == rfc2136PrereqNameInUse {
+		m.Answers = append(m.Answers, dnsRR{Name: name, Type: dnsTypeANY, Class: dnsClassANY})
+	}
+	for _, record := range records {
+		recordType, data := dnsTypeA, net.ParseIP(record.IP).To4()
+		if record.Type == "AAAA" {
+			recordType, data = dnsTypeAAAA, net.ParseIP(record.IP).To16()
+		}
+		if p.prereq == rfc2136PrereqRRsetExists {
+			m.Answers = append(m.Answers, dnsRR{Name: name, Type: recordType, Class: dnsClassANY})
+		}
+		m.Authority = append(m.Authority,
+			dnsRR{Name: name, Type: recordType, Class: dnsClassANY},
+			dnsRR{Name: name, Type: recordType, Class: dnsClassINET, TTL: p.ttl, Data: data},
+		)
+	}
+	return m, nil
+}
+
+func (p *rfc2136Provider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	m, err := p.updateMessage(req.Host, req.AddressRecords())
+	if err != nil {
+		return resultFromError(err)
+	}
+	resp, err := p.exchange(ctx, m)
+	if err != nil {
+		if errors.Is(err, errTSIGVerify) {
+			return &UpdateResult{Code: ResultBadAuth, Body: err.Error()}, nil
+		}
+		return nil, fmt.Errorf("error sending dns update to %s: %v", p.server, err)
+	}
+
+	rcode := resp.Rcode()
+	result := &UpdateResult{Code: ResultGood, Body: fmt.Sprintf("%s %s", dnsRcodeName(rcode), strings.Join(req.IPStrings(), ","))}
+	switch rcode {
+	case dnsRcodeSuccess:
+	case dnsRcodeNotAuth, dnsRcodeRefused:
+		result.Code = ResultBadAuth
+	case dnsRcodeNXDomain, dnsRcodeNXRRSet, dnsRcodeNotZone:
+		result.Code = ResultNoHost
+	default:
+		result.Code = ResultDNSError
+	}
+	return result, nil
+}
+
+// exchange sends an update over UDP, or over TCP when the server is
+// configured for it, the message is too large or the answer was truncated
+func (p *rfc2136Provider) exchange(ctx context.Context, m *dnsMessage) (*dnsMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var packed, requestMAC []byte
+	var err error
+	if p.key != nil {
+		packed, requestMAC, err = p.key.sign(m, time.Now())
+	} else {
+		packed, err = m.pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dnsResolver{timeout: p.timeout}
+	var raw []byte
+	if p.server.transport == dnsTransportUDP && len(packed) <= 512 {
+		raw, err = r.exchangeUDP(ctx, p.server.address, packed)
+		if err == nil && len(raw) >= dnsHeaderLen && raw[2]&byte(dnsFlagTruncated>>8) != 0 {
+			raw = nil
+		}
+	}
+	if err == nil && raw == nil {
+		raw, err = r.exchangeStream(ctx, dnsTransportTCP, p.server, packed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := unpackDNSMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != m.ID || resp.Flags&dnsFlagResponse == 0 || resp.Opcode() != dnsOpcodeUpdate {
+		return nil, fmt.Errorf("unexpected dns response")
+	}
+	if p.key != nil {
+		t, err := p.key.verify(raw, requestMAC, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t == nil && resp.Rcode() == dnsRcodeSuccess:
+			return nil, fmt.Errorf("%w: the answer is not signed", errTSIGVerify)
+		case t != nil && t.Error != 0:
+			name, ok := tsigErrorNames[t.Error]
+			if !ok {
+				name = fmt.Sprint(t.Error)
+			}
+			return nil, fmt.Errorf("%w: server answered %s", errTSIGVerify, name)
+		}
+	}
+	return resp, nil
+}
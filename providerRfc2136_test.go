@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+const testTSIGSecret = "c2VjcmV0LWtleS1mb3ItZGRucy1wcm94eS10ZXN0cw=="
+
+// fakeUpdateServer answers DNS UPDATE messages signed with ddns-key. (hmac-sha256),
+// checking the TSIG with its own encoding rather than the one under test
+type fakeUpdateServer struct {
+	mu        sync.Mutex
+	udp       net.PacketConn
+	tcp       net.Listener
+	rcode     int
+	truncate  bool
+	updates   []*dnsMessage
+	transport []string
+}
+
+func newFakeUpdateServer(t *testing.T) *fakeUpdateServer {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		_ = udp.Close()
+		t.Skipf("can not listen on tcp %s: %v", udp.LocalAddr(), err)
+	}
+	f := &fakeUpdateServer{udp: udp, tcp: tcp}
+	t.Cleanup(func() {
+		_ = udp.Close()
+		_ = tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.transport = append(f.transport, "udp")
+			answer := f.answer(t, append([]byte{}, buf[:n]...), f.truncate)
+			f.mu.Unlock()
+			_, _ = udp.WriteTo(answer, addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := conn.Read(length[:]); err == nil {
+				raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := conn.Read(raw); err == nil {
+					f.mu.Lock()
+					f.transport = append(f.transport, "tcp")
+					answer := f.answer(t, raw, false)
+					f.mu.Unlock()
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+	return f
+}
+
+func testTSIGVariables(timeSigned []byte) []byte {
+	b := []byte("\x08ddns-key\x00\x00\xff\x00\x00\x00\x00\x0bhmac-sha256\x00")
+	b = append(b, timeSigned...)
+	return append(b, 0x01, 0x2c, 0, 0, 0, 0)
+}
+
+func (f *fakeUpdateServer) answer(t *testing.T, raw []byte, truncate bool) []byte {
+	secret, _ := base64.StdEncoding.DecodeString(testTSIGSecret)
+	m, err := unpackDNSMessage(raw)
+	if err != nil {
+		t.Errorf("server got an invalid message: %v", err)
+		return nil
+	}
+	tsig := m.Additional[len(m.Additional)-1]
+	if tsig.Type != dnsTypeTSIG || canonicalDNSName(tsig.Name) != "ddns-key." {
+		t.Errorf("update is not signed")
+		return nil
+	}
+	// algorithm name (13 bytes), time (6), fudge (2), mac size (2), mac
+	timeSigned := tsig.Data[13:19]
+	requestMAC := tsig.Data[23 : 23+binary.BigEndian.Uint16(tsig.Data[21:])]
+	unsigned := append([]byte{}, raw[:len(raw)-(len(tsig.Data)+10+10)]...)
+	unsigned[11]--
+	h := hmac.New(sha256.New, secret)
+	h.Write(unsigned)
+	h.Write(testTSIGVariables(timeSigned))
+	if !hmac.Equal(h.Sum(nil), requestMAC) {
+		// NOTAUTH with an unsigned BADSIG TSIG record
+		resp, _ := (&dnsMessage{ID: m.ID, Flags: dnsFlagResponse | dnsOpcodeUpdate<<11 | dnsRcodeNotAuth, Questions: m.Questions}).pack()
+		data := append([]byte("\x0bhmac-sha256\x00"), timeSigned...)
+		data = append(binary.BigEndian.AppendUint16(append(data, 0x01, 0x2c, 0, 0), m.ID), 0, tsigErrBadSig, 0, 0)
+		resp, _ = appendDNSRR(resp, dnsRR{Name: "ddns-key.", Type: dnsTypeTSIG, Class: dnsClassANY, Data: data})
+		resp[11]++
+		return resp
+	}
+	f.updates = append(f.updates, m)
+
+	flags := dnsFlagResponse | dnsOpcodeUpdate<<11 | uint16(f.rcode)
+	if truncate {
+		flags |= dnsFlagTruncated
+	}
+	resp, _ := (&dnsMessage{ID: m.ID, Flags: flags, Questions: m.Questions}).pack()
+	h = hmac.New(sha256.New, secret)
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+	h.Write(requestMAC)
+	h.Write(resp)
+	h.Write(testTSIGVariables(timeSigned))
+	mac := h.Sum(nil)
+	data := append([]byte("\x0bhmac-sha256\x00"), timeSigned...)
+	data = append(data, 0x01, 0x2c, 0, byte(len(mac)))
+	data = append(data, mac...)
+	data = append(binary.BigEndian.AppendUint16(data, m.ID), 0, 0, 0, 0)
+	resp, _ = appendDNSRR(resp, dnsRR{Name: "ddns-key.", Type: dnsTypeTSIG, Class: dnsClassANY, Data: data})
+	resp[11]++
+	return resp
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	f := newFakeUpdateServer(t)
+	provider, err := newRFC2136Provider("user", gjson.Parse(`{
+		"server": "`+f.udp.LocalAddr().String()+`", "zone": "example.com",
+		"tsig-name": "ddns-key", "tsig-algorithm": "hmac-sha256", "tsig-secret": "`+testTSIGSecret+`",
+		"ttl": 60, "prerequisite": "name-in-use"
+	}`))
+	if err != nil {
+		t.Fatalf("newRFC2136Provider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+
+	result, err := provider.Update(context.Background(), update)
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	f.mu.Lock()
+	m := f.updates[0]
+	f.mu.Unlock()
+	if m.Opcode() != dnsOpcodeUpdate || m.Questions[0].Name != "example.com." || m.Questions[0].Type != dnsTypeSOA {
+		t.Errorf("unexpected zone section %v", m.Questions)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].Type != dnsTypeANY || m.Answers[0].Class != dnsClassANY {
+		t.Errorf("unexpected prerequisites %v", m.Answers)
+	}
+	expected := []dnsRR{
+		{Name: "home.example.com.", Type: dnsTypeA, Class: dnsClassANY},
+		{Name: "home.example.com.", Type: dnsTypeA, Class: dnsClassINET, TTL: 60, Data: net.ParseIP("203.0.113.7").To4()},
+		{Name: "home.example.com.", Type: dnsTypeAAAA, Class: dnsClassANY},
+		{Name: "home.example.com.", Type: dnsTypeAAAA, Class: dnsClassINET, TTL: 60, Data: net.ParseIP("2001:db8::1")},
+	}
+	for i, rr := range m.Authority {
+		if rr.Name != expected[i].Name || rr.Type != expected[i].Type || rr.Class != expected[i].Class || rr.TTL != expected[i].TTL || string(rr.Data) != string(expected[i].Data) {
+			t.Errorf("update record %d expected %v but got %v", i, expected[i], rr)
+		}
+	}
+
+	testCases := map[int]ResultCode{
+		dnsRcodeNotAuth:  ResultBadAuth,
+		dnsRcodeRefused:  ResultBadAuth,
+		dnsRcodeNXRRSet:  ResultNoHost,
+		dnsRcodeYXDomain: ResultDNSError,
+		dnsRcodeServFail: ResultDNSError,
+	}
+	for rcode, expected := range testCases {
+		f.mu.Lock()
+		f.rcode = rcode
+		f.mu.Unlock()
+		if result, err := provider.Update(context.Background(), update); err != nil || result.Code != expected {
+			t.Errorf("rcode %s expected %s but got %v, %v", dnsRcodeName(rcode), expected, result, err)
+		}
+	}
+
+	if result, _ := provider.Update(context.Background(), &UpdateRequest{Host: "home.example.org", IPs: update.IPs}); result.Code != ResultNoHost {
+		t.Errorf("host outside the zone expected %s but got %v", ResultNoHost, result)
+	}
+}
+
+func TestRFC2136ProviderTCPFallback(t *testing.T) {
+	f := newFakeUpdateServer(t)
+	f.mu.Lock()
+	f.truncate = true
+	f.mu.Unlock()
+	provider, err := newRFC2136Provider("user", gjson.Parse(`{
+		"server": "`+f.udp.LocalAddr().String()+`", "zone": "example.com.",
+		"tsig-name": "ddns-key.", "tsig-secret": "`+testTSIGSecret+`"
+	}`))
+	if err != nil {
+		t.Fatalf("newRFC2136Provider failed: %v", err)
+	}
+	result, err := provider.Update(context.Background(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+	if err != nil || result.Code != ResultGood {
+		t.Fatalf("update expected %s but got %v, %v", ResultGood, result, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.transport) != 2 || f.transport[0] != "udp" || f.transport[1] != "tcp" {
+		t.Errorf("truncated answer was not retried over tcp: %v", f.transport)
+	}
+}
+
+func TestRFC2136ProviderBadKey(t *testing.T) {
+	f := newFakeUpdateServer(t)
+	provider, err := newRFC2136Provider("user", gjson.Parse(`{
+		"server": "`+f.udp.LocalAddr().String()+`", "zone": "example.com",
+		"tsig-name": "ddns-key", "tsig-secret": "d3Jvbmcta2V5"
+	}`))
+	if err != nil {
+		t.Fatalf("newRFC2136Provider failed: %v", err)
+	}
+	// the server refuses the signature with BADSIG
+	result, err := provider.Update(context.Background(), &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}})
+	if err != nil || result.Code != ResultBadAuth {
+		t.Errorf("update with a wrong key expected %s but got %v, %v", ResultBadAuth, result, err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	providerRoute53 = "route53"
+
+	defaultRoute53Endpoint = "https://route53.amazonaws.com"
+	route53APIVersion      = "2013-04-01"
+	route53Namespace       = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// Route 53 is a global service signed for us-east-1
+	route53Region  = "us-east-1"
+	route53Service = "route53"
+
+	defaultRoute53TTL = 300
+	// defaultRoute53WaitTimeout stays below the default write-timeout, so the
+	// client still gets the answer
+	defaultRoute53WaitTimeout  = 30 * time.Second
+	defaultRoute53WaitInterval = 5 * time.Second
+)
+
+// Route 53 error codes meaning the credentials are wrong or not allowed
+var route53AuthErrors = map[string]bool{
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"AccessDenied":                true,
+	"UnrecognizedClientException": true,
+	"ExpiredToken":                true,
+	"IncompleteSignature":         true,
+	"MissingAuthenticationToken":  true,
+}
+
+// route53Provider UPSERTs the A and AAAA records of a host with
+// ChangeResourceRecordSets, signing requests with AWS Signature Version 4.
+// With "wait" it polls GetChange until the change is INSYNC on all Route 53
+// name servers.
+type route53Provider struct {
+	endpoint     string
+	creds        awsCredentials
+	zoneID       string
+	ttl          int64
+	wait         bool
+	waitTimeout  time.Duration
+	waitInterval time.Duration
+}
+
+type route53ChangeInfo struct {
+	ID     string `xml:"Id"`
+	Status string `xml:"Status"`
+}
+
+type route53Change struct {
+	Action string   `xml:"Action"`
+	Name   string   `xml:"ResourceRecordSet>Name"`
+	Type   string   `xml:"ResourceRecordSet>Type"`
+	TTL    int64    `xml:"ResourceRecordSet>TTL"`
+	Values []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53ErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func newRoute53Provider(username string, entry gjson.Result) (Provider, error) {
+	p := &route53Provider{
+		endpoint: strings.TrimSuffix(entry.Get("endpoint").String(), "/"),
+		creds: awsCredentials{
+			AccessKeyID:     entry.Get("access-key-id").String(),
+			SecretAccessKey: entry.Get("secret-access-key").String(),
+			SessionToken:    entry.Get("session-token").String(),
+		},
+		zoneID:       strings.TrimPrefix(entry.Get("hosted-zone-id").String(), "/hostedzone/"),
+		ttl:          defaultRoute53TTL,
+		wait:         entry.Get("wait").Bool(),
+		waitTimeout:  defaultRoute53WaitTimeout,
+		waitInterval: defaultRoute53WaitInterval,
+	}
+	if p.endpoint == "" {
+		p.endpoint = defaultRoute53Endpoint
+	}
+	if p.creds.AccessKeyID == "" && p.creds.SecretAccessKey == "" {
+		p.creds = awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if p.creds.AccessKeyID == "" || p.creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("route53 provider of %s needs access-key-id and secret-access-key, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", username)
+	}
+	if ttl := entry.Get("ttl"); ttl.Exists() {
+		if ttl.Int() <= 0 {
+			return nil, fmt.Errorf("invalid ttl of %s", username)
+		}
+		p.ttl = ttl.Int()
+	}
+	for key, target := range map[string]*time.Duration{"wait-timeout": &p.waitTimeout, "wait-interval": &p.waitInterval} {
+		if value := entry.Get(key).String(); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s of %s", key, username)
+			}
+			*target = d
+		}
+	}
+	if p.wait && cfg != nil && cfg.WriteTimeout > 0 && p.waitTimeout >= cfg.WriteTimeout {
+		getLogger().Warnf("wait-timeout %s of %s is not below write-timeout %s, the client may get no answer", p.waitTimeout, username, cfg.WriteTimeout)
+	}
+	registerSecrets(p.creds.SecretAccessKey, p.creds.SessionToken)
+	return p, nil
+}
+
+func (p *route53Provider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	zoneID, err := p.hostedZoneID(ctx, req.Host)
+	if err != nil {
+		return resultFromError(err)
+	}
+	change := route53ChangeRequest{Xmlns: route53Namespace, Comment: "ddns-proxy update of " + req.Host}
+	for _, record := range req.AddressRecords() {
+		change.Changes = append(change.Changes, route53Change{
+			Action: "UPSERT",
+			Name:   canonicalDNSName(req.Host),
+			Type:   record.Type,
+			TTL:    p.ttl,
+			Values: []string{record.IP},
+		})
+	}
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+
+	var answer struct {
+		ChangeInfo route53ChangeInfo `xml:"ChangeInfo"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/hostedzone/"+zoneID+"/rrset/", nil, append([]byte(xml.Header), body...), &answer); err != nil {
+		return resultFromError(err)
+	}
+	info := answer.ChangeInfo
+	if p.wait {
+		if info, err = p.waitInSync(ctx, info); err != nil {
+			return resultFromError(err)
+		}
+	}
+	return &UpdateResult{
+		Code:       ResultGood,
+		StatusCode: http.StatusOK,
+		Body:       fmt.Sprintf("%s %s %s", strings.TrimPrefix(info.ID, "/change/"), info.Status, strings.Join(req.IPStrings(), ",")),
+	}, nil
+}
+
+// waitInSync polls GetChange until the change is INSYNC or wait-timeout passed
+func (p *route53Provider) waitInSync(ctx context.Context, info route53ChangeInfo) (route53ChangeInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.waitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(p.waitInterval)
+	defer ticker.Stop()
+	for info.Status != "INSYNC" {
+		select {
+		case <-ctx.Done():
+			return info, &providerAPIError{Code: ResultServerError, StatusCode: http.StatusGatewayTimeout,
+				Message: fmt.Sprintf("change %s still %s after %s", info.ID, info.Status, p.waitTimeout)}
+		case <-ticker.C:
+		}
+		var answer struct {
+			ChangeInfo route53ChangeInfo `xml:"ChangeInfo"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/change/"+strings.TrimPrefix(info.ID, "/change/"), nil, nil, &answer); err != nil {
+			return info, err
+		}
+		info = answer.ChangeInfo
+	}
+	return info, nil
+}
+
+// hostedZoneID returns the configured zone, or finds the zone of host with ListHostedZonesByName
+func (p *route53Provider) hostedZoneID(ctx context.Context, host string) (string, error) {
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+	key := "route53/zone/" + host
+	var zoneID string
+	if getState().Get(key, &zoneID) {
+		return zoneID, nil
+	}
+	for _, name := range zoneCandidates(host) {
+		var answer struct {
+			HostedZones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		query := url.Values{"dnsname": {name}, "maxitems": {"1"}}
+		if err := p.do(ctx, http.MethodGet, "/hostedzonesbyname", query, nil, &answer); err != nil {
+			return "", err
+		}
+		// the list starts at dnsname, the first zone may be a later one
+		if len(answer.HostedZones) > 0 && canonicalDNSName(answer.HostedZones[0].Name) == canonicalDNSName(name) {
+			zoneID = strings.TrimPrefix(answer.HostedZones[0].ID, "/hostedzone/")
+			getState().Set(key, zoneID, 24*time.Hour)
+			return zoneID, nil
+		}
+	}
+	return "", &providerAPIError{Code: ResultNoHost, StatusCode: http.StatusNotFound, Message: "no Route 53 hosted zone found for " + host}
+}
+
+// do sends a signed request to the Route 53 API and decodes the XML answer into out
+func (p *route53Provider) do(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	target := p.endpoint + "/" + route53APIVersion + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error building Route 53 request: %v", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "text/xml")
+	}
+	signAWSRequest(httpReq, body, p.creds, route53Region, route53Service, time.Now())
+
+	getLogger().Debugf("Calling Route 53 %s %s", method, path)
+	resp, data, err := doProviderRequest(httpReq)
+	if err != nil {
+		return fmt.Errorf("error calling Route 53: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		apiErr := &providerAPIError{Code: httpStatusResultCode(resp.StatusCode), StatusCode: resp.StatusCode, Message: string(data)}
+		var answer route53ErrorResponse
+		if xml.Unmarshal(data, &answer) == nil && answer.Code != "" {
+			apiErr.Message = answer.Code + ": " + answer.Message
+			switch {
+			case route53AuthErrors[answer.Code]:
+				apiErr.Code = ResultBadAuth
+			case answer.Code == "NoSuchHostedZone":
+				apiErr.Code = ResultNoHost
+			default:
+				apiErr.Code = ResultServerError
+			}
+		}
+		return apiErr
+	}
+	if out != nil {
+		if err := xml.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid Route 53 answer: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestRoute53Provider(t *testing.T) {
+	var change route53ChangeRequest
+	polls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/us-east-1/route53/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error></ErrorResponse>`))
+			return
+		}
+		switch {
+		case r.URL.Path == "/2013-04-01/hostedzonesbyname":
+			name := r.URL.Query().Get("dnsname")
+			if name == "home.example.com" {
+				name = "example.com"
+			}
+			_, _ = w.Write([]byte(`<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z123</Id><Name>` + name + `.</Name></HostedZone></HostedZones></ListHostedZonesByNameResponse>`))
+		case r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset/" && r.Method == http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			_ = xml.Unmarshal(body, &change)
+			_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+		case r.URL.Path == "/2013-04-01/change/C1":
+			polls++
+			status := "PENDING"
+			if polls > 1 {
+				status = "INSYNC"
+			}
+			_, _ = w.Write([]byte(`<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>` + status + `</Status></ChangeInfo></GetChangeResponse>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Code>NoSuchHostedZone</Code><Message>no zone</Message></Error></ErrorResponse>`))
+		}
+	}))
+	defer api.Close()
+	useMemoryState(t)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	provider, err := newRoute53Provider("user", gjson.Parse(`{"endpoint": "`+api.URL+`", "ttl": 60, "wait": true, "wait-interval": "10ms"}`))
+	if err != nil {
+		t.Fatalf("newRoute53Provider failed: %v", err)
+	}
+	update := &UpdateRequest{Host: "home.example.com", IPs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::1")}}
+	result, err := provider.Update(localProviderContext(), update)
+	if err != nil || result.Code != ResultGood || !strings.HasPrefix(result.Body, "C1 INSYNC") {
+		t.Fatalf("update expected %s INSYNC but got %v, %v", ResultGood, result, err)
+	}
+	if polls != 2 {
+		t.Errorf("GetChange was polled %d times", polls)
+	}
+	if len(change.Changes) != 2 || change.Changes[0].Action != "UPSERT" || change.Changes[0].Name != "home.example.com." ||
+		change.Changes[0].Type != "A" || change.Changes[0].TTL != 60 || change.Changes[1].Values[0] != "2001:db8::1" {
+		t.Errorf("unexpected change batch %+v", change)
+	}
+
+	testCases := map[string]ResultCode{
+		`{"access-key-id": "WRONG", "secret-access-key": "x", "hosted-zone-id": "Z123"}`:                 ResultBadAuth,
+		`{"access-key-id": "AKIDEXAMPLE", "secret-access-key": "x", "hosted-zone-id": "/hostedzone/Z9"}`: ResultNoHost,
+	}
+	for entry, expected := range testCases {
+		provider, err := newRoute53Provider("user", gjson.Parse(strings.Replace(entry, "{", `{"endpoint": "`+api.URL+`", `, 1)))
+		if err != nil {
+			t.Fatalf("newRoute53Provider(%s) failed: %v", entry, err)
+		}
+		if result, err := provider.Update(localProviderContext(), update); err != nil || result.Code != expected {
+			t.Errorf("entry %s expected %s but got %v, %v", entry, expected, result, err)
+		}
+	}
+}
+
+func TestRoute53WaitTimeout(t *testing.T) {
+	savedCfg, savedOutput := cfg, getLogger().Out
+	t.Cleanup(func() { cfg = savedCfg; getLogger().SetOutput(savedOutput) })
+	cfg = &ServerConfig{WriteTimeout: 60 * time.Second}
+	var logged bytes.Buffer
+	getLogger().SetOutput(&logged)
+
+	testCases := map[string]bool{
+		`"wait": true`:                         false,
+		`"wait": true, "wait-timeout": "60s"`:  true,
+		`"wait": false, "wait-timeout": "90s"`: false,
+	}
+	for entry, warned := range testCases {
+		logged.Reset()
+		newTestProvider(t, newRoute53Provider, `{"access-key-id": "AKIDEXAMPLE", "secret-access-key": "secret-key", `+entry+`}`)
+		if strings.Contains(logged.String(), "write-timeout") != warned {
+			t.Errorf("%s expected a warning %v but logged %q", entry, warned, logged.String())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+// urlProviderBuiltins are the placeholders the url provider fills itself
+var urlProviderBuiltins = []string{"ddhost", "dduser", "ddpass", "ddip", "ddipv4", "ddipv6"}
+
+// urlProvider calls a GET URL built from the "url" pattern of the credential
+// entry, the Google Domains dyndns2 endpoint by default. Request parameters
+// only reach the URL through the variables declared in "params".
+type urlProvider struct {
+	pattern *compiledTemplate
+	vars    templateVars
+}
+
+func newURLProvider(_ string, entry gjson.Result) (Provider, error) {
+	pattern := defaultURLPattern
+	if entry.Get("url").String() != "" {
+		pattern = entry.Get("url").String()
+	}
+	vars, err := parseTemplateVars(entry, urlProviderBuiltins)
+	if err != nil {
+		return nil, err
+	}
+	if err := vars.checkPlaceholders(pattern, urlProviderBuiltins); err != nil {
+		return nil, err
+	}
+	compiled, err := compileURLTemplate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &urlProvider{pattern: compiled, vars: vars}, nil
+}
+
+// addBuiltinValues sets the values of urlProviderBuiltins from the update
+func addBuiltinValues(values map[string]interface{}, req *UpdateRequest) {
+	values["ddhost"] = req.Host
+	values["dduser"] = req.Creds.DDUser
+	values["ddpass"] = req.Creds.DDPass
+	values["ddip"] = req.IPStrings()[0]
+	values["ddipv4"] = req.IPv4()
+	values["ddipv6"] = req.IPv6()
+}
+
+func (p *urlProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	values, err := p.vars.resolve(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	addBuiltinValues(values, req)
+
+	// Placeholders are replaced in a single pass and escaped for the part of
+	// the URL they are in, values are never interpolated again
+	theUrl := p.pattern.Execute(values)
+	// Send an HTTP GET request using the shared upstream client, canceled with the incoming request
+	getLogger().Debugf("Compiled URL using parameters: %v", []string{"URL", theUrl})
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, theUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building GET request: %v", err)
+	}
+	resp, body, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making GET request: %v", err)
+	}
+
+	getLogger().Debug("Body: ", string(body))
+	getLogger().Debug("Header: ", resp.Header)
+
+	result := &UpdateResult{Code: ResultGood, StatusCode: resp.StatusCode, Body: string(body)}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		result.Code = ResultBadAuth
+	default:
+		result.Code = ResultServerError
+	}
+	return result, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	webhookBodyJSON = "json"
+	webhookBodyForm = "form"
+	webhookBodyText = "text"
+)
+
+// webhookProvider calls a REST API described entirely by the credential entry:
+//
+//	"provider": "webhook",
+//	"method": "PUT",
+//	"url": "https://api.example.com/zones/{zone}/records/{ddhost}",
+//	"headers": {"Authorization": "Bearer {ddpass}"},
+//	"body": {"type": "A", "content": "{ddipv4}", "ttl": "{ttl}"},
+//	"params": {"zone": {"type": "hostname", "required": true}, "ttl": {"type": "int", "default": "300"}},
+//	"expect-status": [200, 201],
+//	"success": {"json": "success", "equals": "true"}
+//
+// Object and array bodies are sent as JSON with their string values filled in,
+// or url encoded with "body-format": "form". A string body is a template sent
+// as it is.
+type webhookProvider struct {
+	method       string
+	url          *compiledTemplate
+	headers      map[string]*compiledTemplate
+	body         interface{}
+	bodyFormat   string
+	vars         templateVars
+	expectStatus []int
+	success      *webhookMatch
+	failure      *webhookMatch
+	noChange     *webhookMatch
+}
+
+// webhookMatch tests a response body, either the value at a gjson path or a regular expression
+type webhookMatch struct {
+	path   string
+	equals *string
+	regex  *regexp.Regexp
+}
+
+func newWebhookProvider(username string, entry gjson.Result) (Provider, error) {
+	p := &webhookProvider{
+		method:     strings.ToUpper(entry.Get("method").String()),
+		headers:    make(map[string]*compiledTemplate),
+		bodyFormat: strings.ToLower(entry.Get("body-format").String()),
+	}
+	if p.method == "" {
+		p.method = http.MethodPost
+	}
+	rawURL := entry.Get("url").String()
+	if rawURL == "" {
+		return nil, fmt.Errorf("webhook provider of %s needs a url", username)
+	}
+	vars, err := parseTemplateVars(entry, urlProviderBuiltins)
+	if err != nil {
+		return nil, err
+	}
+	p.vars = vars
+
+	check := func(template string) (*compiledTemplate, error) {
+		if err := vars.checkPlaceholders(template, urlProviderBuiltins); err != nil {
+			return nil, err
+		}
+		return compileTemplate(template)
+	}
+	if err := vars.checkPlaceholders(rawURL, urlProviderBuiltins); err != nil {
+		return nil, err
+	}
+	if p.url, err = compileURLTemplate(rawURL); err != nil {
+		return nil, err
+	}
+
+	entry.Get("headers").ForEach(func(key, value gjson.Result) bool {
+		var header *compiledTemplate
+		if header, err = check(value.String()); err != nil {
+			return false
+		}
+		if len(header.Placeholders()) == 0 {
+			// literal header values are usually API tokens
+			registerSecrets(value.String())
+		}
+		p.headers[http.CanonicalHeaderKey(key.String())] = header
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if body := entry.Get("body"); body.Exists() {
+		switch {
+		case body.Type == gjson.String:
+			if p.bodyFormat == "" {
+				p.bodyFormat = webhookBodyText
+			}
+			if p.body, err = check(body.String()); err != nil {
+				return nil, err
+			}
+		case body.IsObject() || body.IsArray():
+			if p.bodyFormat == "" {
+				p.bodyFormat = webhookBodyJSON
+			}
+			if p.bodyFormat == webhookBodyForm && !body.IsObject() {
+				return nil, fmt.Errorf("form body of %s must be an object", username)
+			}
+			decoder := json.NewDecoder(strings.NewReader(body.Raw))
+			decoder.UseNumber()
+			if err := decoder.Decode(&p.body); err != nil {
+				return nil, fmt.Errorf("body of %s: %v", username, err)
+			}
+			if err := walkWebhookBody(p.body, func(s string) error {
+				_, err := check(s)
+				return err
+			}); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("body of %s must be a string, object or array", username)
+		}
+		if p.bodyFormat != webhookBodyJSON && p.bodyFormat != webhookBodyForm && p.bodyFormat != webhookBodyText {
+			return nil, fmt.Errorf("unknown body-format %q of %s", p.bodyFormat, username)
+		}
+	}
+
+	for _, status := range entry.Get("expect-status").Array() {
+		p.expectStatus = append(p.expectStatus, int(status.Int()))
+	}
+	for key, match := range map[string]**webhookMatch{"success": &p.success, "failure": &p.failure, "nochg": &p.noChange} {
+		if *match, err = parseWebhookMatch(entry.Get(key)); err != nil {
+			return nil, fmt.Errorf("%s match of %s: %v", key, username, err)
+		}
+	}
+	return p, nil
+}
+
+func parseWebhookMatch(rule gjson.Result) (*webhookMatch, error) {
+	if !rule.Exists() {
+		return nil, nil
+	}
+	m := &webhookMatch{path: rule.Get("json").String()}
+	if equals := rule.Get("equals"); equals.Exists() {
+		value := equals.String()
+		m.equals = &value
+	}
+	if pattern := rule.Get("regex").String(); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.regex = re
+	}
+	if (m.path == "") == (m.regex == nil) {
+		return nil, fmt.Errorf("needs either a json path or a regex")
+	}
+	return m, nil
+}
+
+// matches reports whether body satisfies the rule. A json path without
+// "equals" matches when the value exists and is not false, null or empty.
+func (m *webhookMatch) matches(body string) bool {
+	if m.regex != nil {
+		return m.regex.MatchString(body)
+	}
+	value := gjson.Get(body, m.path)
+	if m.equals != nil {
+		return value.Exists() && value.String() == *m.equals
+	}
+	return value.Exists() && value.Type != gjson.Null && value.Type != gjson.False && value.String() != ""
+}
+
+// walkWebhookBody calls fn for every string in a decoded JSON body
+func walkWebhookBody(body interface{}, fn func(string) error) error {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			if err := walkWebhookBody(value, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := walkWebhookBody(value, fn); err != nil {
+				return err
+			}
+		}
+	case string:
+		return fn(v)
+	}
+	return nil
+}
+
+// fillWebhookBody returns a copy of a decoded JSON body with its strings
+// filled in. A string that is a single placeholder of an int variable becomes
+// a JSON number.
+func (p *webhookProvider) fillWebhookBody(body interface{}, values map[string]interface{}) interface{} {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			filled[key] = p.fillWebhookBody(value, values)
+		}
+		return filled
+	case []interface{}:
+		filled := make([]interface{}, len(v))
+		for i, value := range v {
+			filled[i] = p.fillWebhookBody(value, values)
+		}
+		return filled
+	case string:
+		t, err := compileTemplate(v)
+		if err != nil {
+			return v
+		}
+		filled := t.Execute(values)
+		if names := t.Placeholders(); len(names) == 1 && v == "{"+names[0]+"}" {
+			if declared, ok := p.vars[names[0]]; ok && declared.Type == varTypeInt && filled != "" {
+				return json.Number(filled)
+			}
+		}
+		return filled
+	}
+	return body
+}
+
+func (p *webhookProvider) Update(ctx context.Context, req *UpdateRequest) (*UpdateResult, error) {
+	values, err := p.vars.resolve(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	addBuiltinValues(values, req)
+
+	var body []byte
+	contentType := ""
+	switch b := p.body.(type) {
+	case *compiledTemplate:
+		body = []byte(b.Execute(values))
+		contentType = "text/plain; charset=utf-8"
+		if p.bodyFormat == webhookBodyJSON {
+			contentType = "application/json"
+		}
+	case nil:
+	default:
+		filled := p.fillWebhookBody(b, values)
+		if p.bodyFormat == webhookBodyForm {
+			form := url.Values{}
+			for key, value := range filled.(map[string]interface{}) {
+				form.Set(key, fmt.Sprint(value))
+			}
+			body = []byte(form.Encode())
+			contentType = "application/x-www-form-urlencoded"
+		} else {
+			if body, err = json.Marshal(filled); err != nil {
+				return nil, fmt.Errorf("error encoding webhook body: %v", err)
+			}
+			contentType = "application/json"
+		}
+	}
+
+	theUrl := p.url.Execute(values)
+	getLogger().Debugf("Calling webhook %s %s", p.method, theUrl)
+	httpReq, err := http.NewRequestWithContext(ctx, p.method, theUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error building webhook request: %v", err)
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	for name, header := range p.headers {
+		httpReq.Header.Set(name, header.Execute(values))
+	}
+
+	resp, respBody, err := doProviderRequest(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling webhook: %v", err)
+	}
+	return &UpdateResult{
+		Code:       p.resultCode(resp.StatusCode, string(respBody)),
+		StatusCode: resp.StatusCode,
+		Body:       string(respBody),
+	}, nil
+}
+
+func (p *webhookProvider) resultCode(status int, body string) ResultCode {
+	expected := status >= 200 && status < 300
+	if len(p.expectStatus) > 0 {
+		expected = false
+		for _, s := range p.expectStatus {
+			expected = expected || s == status
+		}
+	}
+	switch {
+	case !expected && (status == http.StatusUnauthorized || status == http.StatusForbidden):
+		return ResultBadAuth
+	case !expected:
+		return ResultServerError
+	case p.failure != nil && p.failure.matches(body):
+		return ResultServerError
+	case p.noChange != nil && p.noChange.matches(body):
+		return ResultNoChange
+	case p.success != nil && !p.success.matches(body):
+		return ResultServerError
+	}
+	return ResultGood
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestWebhookProvider(t *testing.T) {
+	var method, path, auth, contentType, body string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, auth, contentType = r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		switch r.URL.Query().Get("mode") {
+		case "denied":
+			w.WriteHeader(http.StatusForbidden)
+		case "failed":
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"message": "record not found"}]}`))
+		case "unchanged":
+			_, _ = w.Write([]byte(`{"success": true, "result": {"changed": false}}`))
+		default:
+			_, _ = w.Write([]byte(`{"success": true, "result": {"changed": true}}`))
+		}
+	}))
+	defer api.Close()
+
+	provider, err := newWebhookProvider("user", gjson.Parse(`{
+		"provider": "webhook",
+		"method": "put",
+		"url": "`+api.URL+`/zones/{zone}/records/{ddhost}?mode={mode}",
+		"headers": {"Authorization": "Bearer {ddpass}"},
+		"body": {"type": "A", "content": "{ddipv4}", "ttl": "{ttl}", "tags": ["ddns {ddhost}"]},
+		"params": {
+			"zone": {"type": "hostname", "required": true},
+			"ttl":  {"type": "int", "default": "300"},
+			"mode": {"type": "enum", "values": ["ok", "denied", "failed", "unchanged"], "default": "ok"}
+		},
+		"expect-status": [200],
+		"success": {"json": "success"},
+		"nochg": {"json": "result.changed", "equals": "false"}
+	}`))
+	if err != nil {
+		t.Fatalf("newWebhookProvider failed: %v", err)
+	}
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+	ctx := withOutboundPolicy(context.Background(), policy)
+
+	testCases := []map[string]interface{}{
+		{"mode": "ok", "expected": ResultGood},
+		{"mode": "denied", "expected": ResultBadAuth},
+		{"mode": "failed", "expected": ResultServerError},
+		{"mode": "unchanged", "expected": ResultNoChange},
+	}
+
+	for _, testCase := range testCases {
+		result, err := provider.Update(ctx, &UpdateRequest{
+			Host:   "home.example.com",
+			IPs:    []net.IP{net.ParseIP("203.0.113.7")},
+			Creds:  &UserInfo{DDPass: "t0ken"},
+			Params: map[string]interface{}{"zone": "example.com", "mode": testCase["mode"]},
+		})
+		if err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+		if result.Code != testCase["expected"].(ResultCode) {
+			t.Errorf("mode %s expected %s but got %s", testCase["mode"], testCase["expected"], result.Code)
+		}
+	}
+
+	if method != http.MethodPut || path != "/zones/example.com/records/home.example.com" || auth != "Bearer t0ken" || contentType != "application/json" {
+		t.Errorf("unexpected request: %s %s %q %q", method, path, auth, contentType)
+	}
+	if body != `{"content":"203.0.113.7","tags":["ddns home.example.com"],"ttl":300,"type":"A"}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestWebhookProviderFormBody(t *testing.T) {
+	var contentType, body string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		_, _ = w.Write([]byte("status=ok"))
+	}))
+	defer api.Close()
+
+	provider, err := newWebhookProvider("user", gjson.Parse(`{
+		"url": "`+api.URL+`/update",
+		"body-format": "form",
+		"body": {"host": "{ddhost}", "ip": "{ddip}", "key": "{ddpass}"},
+		"success": {"regex": "^status=ok"}
+	}`))
+	if err != nil {
+		t.Fatalf("newWebhookProvider failed: %v", err)
+	}
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+
+	result, err := provider.Update(withOutboundPolicy(context.Background(), policy), &UpdateRequest{
+		Host:  "home.example.com",
+		IPs:   []net.IP{net.ParseIP("2001:db8::1")},
+		Creds: &UserInfo{DDPass: "a&b"},
+	})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if result.Code != ResultGood {
+		t.Errorf("expected %s but got %s", ResultGood, result.Code)
+	}
+	if contentType != "application/x-www-form-urlencoded" || body != "host=home.example.com&ip=2001%3Adb8%3A%3A1&key=a%26b" {
+		t.Errorf("unexpected form request %q: %s", contentType, body)
+	}
+}
+
+func TestWebhookProviderConfig(t *testing.T) {
+	testCases := map[string]bool{
+		`{"url": "https://api.example.com/{ddhost}"}`: true,
+		`{}`: false,
+		`{"url": "https://api.example.com/{zone}"}`:                                      false,
+		`{"url": "https://api.example.com/", "headers": {"X-Zone": "{zone}"}}`:           false,
+		`{"url": "https://api.example.com/", "body": {"zone": "{zone|nope}"}}`:           false,
+		`{"url": "https://api.example.com/", "body": ["a"], "body-format": "form"}`:      false,
+		`{"url": "https://api.example.com/", "body": "x", "body-format": "xml"}`:         false,
+		`{"url": "https://api.example.com/", "success": {"regex": "("}}`:                 false,
+		`{"url": "https://api.example.com/", "success": {"json": "ok", "regex": "^ok"}}`: false,
+	}
+
+	for entry, valid := range testCases {
+		if _, err := newWebhookProvider("user", gjson.Parse(entry)); (err == nil) != valid {
+			t.Errorf("newWebhookProvider(%s) expected valid=%v but got %v", entry, valid, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// localProviderContext allows provider calls to the local test servers
+func localProviderContext() context.Context {
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+	return withOutboundPolicy(context.Background(), policy)
+}
+
+// useMemoryState replaces the state store with an empty one kept in memory
+// for the rest of the test
+func useMemoryState(t *testing.T) {
+	previous := state
+	state = newStateStore("")
+	t.Cleanup(func() { state = previous })
+}
+
+// newTestProvider creates a provider from the JSON entry and stops the test
+// when the entry is refused
+func newTestProvider(t *testing.T, factory providerFactory, entry string) Provider {
+	t.Helper()
+	provider, err := factory("user", gjson.Parse(entry))
+	if err != nil {
+		t.Fatalf("creating the provider from %s failed: %v", entry, err)
+	}
+	return provider
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// providerRateLimit keeps a provider from calling an API again before its
+// rate limit resets. It reads the Retry-After of 429 answers and the
+// RateLimit-Remaining and RateLimit-Reset headers (with or without the X-
+// prefix) of every answer.
+type providerRateLimit struct {
+	name string
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// check returns an error while the API is known to refuse calls
+func (l *providerRateLimit) check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if wait := time.Until(l.until); wait > 0 {
+		return &providerAPIError{Code: ResultServerError, StatusCode: http.StatusTooManyRequests,
+			Message: fmt.Sprintf("%s rate limit reached, retry in %s", l.name, wait.Round(time.Second))}
+	}
+	return nil
+}
+
+// remaining returns how long the API is still known to refuse calls
+func (l *providerRateLimit) remaining() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if wait := time.Until(l.until); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// observe records when the API accepts calls again after resp
+func (l *providerRateLimit) observe(resp *http.Response) {
+	wait := rateLimitWait(resp.Header, resp.StatusCode, time.Now())
+	if wait <= 0 {
+		return
+	}
+	getLogger().Warnf("%s rate limit reached, not calling it for %s", l.name, wait.Round(time.Second))
+	l.mu.Lock()
+	l.until = time.Now().Add(wait)
+	l.mu.Unlock()
+}
+
+// rateLimitWait returns how long to wait before the next call, 0 when the
+// answer does not ask for it
+func rateLimitWait(header http.Header, status int, now time.Time) time.Duration {
+	if status == http.StatusTooManyRequests {
+		if retry := header.Get("Retry-After"); retry != "" {
+			if seconds, err := strconv.ParseInt(retry, 10, 64); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+			if at, err := http.ParseTime(retry); err == nil {
+				return at.Sub(now)
+			}
+		}
+	}
+	if status != http.StatusTooManyRequests && headerWithPrefix(header, "Ratelimit-Remaining") != "0" {
+		return 0
+	}
+	reset, err := strconv.ParseInt(headerWithPrefix(header, "Ratelimit-Reset"), 10, 64)
+	switch {
+	case err != nil:
+		// limited without a reset time, wait a little
+		return time.Minute
+	case reset > 1e9:
+		// a unix timestamp
+		return time.Unix(reset, 0).Sub(now)
+	}
+	// seconds until the reset
+	return time.Duration(reset) * time.Second
+}
+
+// headerWithPrefix returns the header, or its X- prefixed form
+func headerWithPrefix(header http.Header, name string) string {
+	if value := header.Get(name); value != "" {
+		return value
+	}
+	return header.Get("X-" + name)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := map[string]struct {
+		status   int
+		headers  map[string]string
+		expected time.Duration
+	}{
+		"ok":                  {http.StatusOK, map[string]string{"RateLimit-Remaining": "4999", "RateLimit-Reset": "1700000060"}, 0},
+		"no headers":          {http.StatusOK, nil, 0},
+		"exhausted timestamp": {http.StatusOK, map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "1700000060"}, time.Minute},
+		"exhausted seconds":   {http.StatusOK, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "30"}, 30 * time.Second},
+		"retry after":         {http.StatusTooManyRequests, map[string]string{"Retry-After": "12"}, 12 * time.Second},
+		"retry after date":    {http.StatusTooManyRequests, map[string]string{"Retry-After": now.Add(90 * time.Second).UTC().Format(http.TimeFormat)}, 90 * time.Second},
+		"429 with reset":      {http.StatusTooManyRequests, map[string]string{"X-RateLimit-Reset": "1700000005"}, 5 * time.Second},
+		"429 bare":            {http.StatusTooManyRequests, nil, time.Minute},
+	}
+	for name, testCase := range testCases {
+		header := http.Header{}
+		for key, value := range testCase.headers {
+			header.Set(key, value)
+		}
+		if wait := rateLimitWait(header, testCase.status, now); wait != testCase.expected {
+			t.Errorf("%s expected %s but got %s", name, testCase.expected, wait)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTransportUDP   = "udp"
+	dnsTransportTCP   = "tcp"
+	dnsTransportTLS   = "tls"
+	dnsTransportHTTPS = "https"
+
+	dnsMessageContentType = "application/dns-message"
+)
+
+var defaultDNSPorts = map[string]string{
+	dnsTransportUDP: "53",
+	dnsTransportTCP: "53",
+	dnsTransportTLS: "853",
+}
+
+// errNoSuchHost is returned when a server authoritatively answers NXDOMAIN
+var errNoSuchHost = errors.New("no such host")
+
+// dnsServer is one upstream of the resolver: plain DNS over UDP (falling back
+// to TCP on truncation) or TCP, DNS over TLS (RFC 7858) or DNS over HTTPS (RFC 8484).
+type dnsServer struct {
+	transport  string
+	address    string
+	serverName string
+	url        string
+}
+
+// dnsResolver looks up the current records of a host through the configured
+// servers, trying them in order until one answers.
+type dnsResolver struct {
+	servers    []dnsServer
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	httpClient func() *http.Client
+}
+
+// hostResolver is used for the no-change lookup, nil means the system resolver
+var hostResolver *dnsResolver
+
+// parseDNSServer parses udp://9.9.9.9:53, tcp://9.9.9.9, tls://1.1.1.1:853?servername=cloudflare-dns.com,
+// https://dns.google/dns-query or a bare address (udp)
+func parseDNSServer(spec string) (dnsServer, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.Contains(spec, "://") {
+		spec = dnsTransportUDP + "://" + spec
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return dnsServer{}, fmt.Errorf("invalid resolver %q: %v", spec, err)
+	}
+	transport := strings.ToLower(u.Scheme)
+	if u.Host == "" {
+		return dnsServer{}, fmt.Errorf("resolver %q has no host", spec)
+	}
+
+	switch transport {
+	case dnsTransportHTTPS:
+		return dnsServer{transport: transport, url: u.String()}, nil
+	case dnsTransportUDP, dnsTransportTCP, dnsTransportTLS:
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(strings.Trim(u.Host, "[]"), defaultDNSPorts[transport])
+		}
+		server := dnsServer{transport: transport, address: address}
+		if transport == dnsTransportTLS {
+			server.serverName = u.Query().Get("servername")
+			if server.serverName == "" {
+				server.serverName = u.Hostname()
+			}
+		}
+		return server, nil
+	default:
+		return dnsServer{}, fmt.Errorf("unsupported resolver transport %q, use udp, tcp, tls or https", u.Scheme)
+	}
+}
+
+func (s dnsServer) String() string {
+	if s.transport == dnsTransportHTTPS {
+		return s.url
+	}
+	return s.transport + "://" + s.address
+}
+
+// newDNSResolver builds a resolver for the given server specs. DoT servers
+// use tlsConfig (nil for the system roots), DoH servers the upstream client.
+func newDNSResolver(specs []string, timeout time.Duration, tlsConfig *tls.Config) (*dnsResolver, error) {
+	r := &dnsResolver{timeout: timeout, tlsConfig: tlsConfig, httpClient: getUpstreamClient}
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		server, err := parseDNSServer(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.servers = append(r.servers, server)
+	}
+	if len(r.servers) == 0 {
+		return nil, fmt.Errorf("no resolver configured")
+	}
+	return r, nil
+}
+
+// setupHostResolver installs the resolver configured in c, if any
+func setupHostResolver(c *ServerConfig) error {
+	if len(c.Resolvers) == 0 {
+		return nil
+	}
+	r, err := newDNSResolver(c.Resolvers, c.ResolverTimeout, upstreamTLSConfig())
+	if err != nil {
+		return err
+	}
+	hostResolver = r
+	return nil
+}
+
+// lookupHostIPs resolves host with the context of the incoming request, so the
+// lookup is abandoned when the client goes away
+func lookupHostIPs(ctx context.Context, host string) ([]net.IP, error) {
+	if hostResolver != nil {
+		return hostResolver.LookupIP(ctx, host)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Upstream.DialTimeout)
+	defer cancel()
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// LookupIP returns the A and AAAA records of host. Servers are tried in order;
+// the next one is used when a server can not be reached, times out or fails.
+func (r *dnsResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	var lastErr error
+	for _, server := range r.servers {
+		ips, err := r.lookupWith(ctx, server, host)
+		if err == nil || errors.Is(err, errNoSuchHost) {
+			return ips, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		getLogger().Debugf("Resolver %s failed for %s: %v", server, host, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("all resolvers failed, last error: %v", lastErr)
+}
+
+func (r *dnsResolver) lookupWith(ctx context.Context, server dnsServer, host string) ([]net.IP, error) {
+	var ips []net.IP
+	nxdomain := 0
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		resp, err := r.exchange(ctx, server, newDNSQuery(host, qtype))
+		if err != nil {
+			return nil, err
+		}
+		switch resp.Rcode() {
+		case dnsRcodeSuccess:
+			ips = append(ips, addressesOf(resp.Answers)...)
+		case dnsRcodeNXDomain:
+			nxdomain++
+		default:
+			return nil, fmt.Errorf("server answered %s", dnsRcodeName(resp.Rcode()))
+		}
+	}
+	if nxdomain == 2 {
+		return nil, errNoSuchHost
+	}
+	return ips, nil
+}
+
+// exchange sends query to server and returns the matching response
+func (r *dnsResolver) exchange(ctx context.Context, server dnsServer, query *dnsMessage) (*dnsMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if server.transport == dnsTransportHTTPS {
+		// RFC 8484 recommends ID 0 so responses can be cached
+		query.ID = 0
+	}
+	packed, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	switch server.transport {
+	case dnsTransportUDP:
+		raw, err = r.exchangeUDP(ctx, server.address, packed)
+		if err == nil {
+			var resp *dnsMessage
+			if resp, err = unpackDNSMessage(raw); err == nil && resp.Flags&dnsFlagTruncated != 0 {
+				raw, err = r.exchangeStream(ctx, dnsTransportTCP, server, packed)
+			}
+		}
+	case dnsTransportTCP, dnsTransportTLS:
+		raw, err = r.exchangeStream(ctx, server.transport, server, packed)
+	case dnsTransportHTTPS:
+		raw, err = r.exchangeHTTPS(ctx, server.url, packed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := unpackDNSMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != query.ID || resp.Flags&dnsFlagResponse == 0 {
+		return nil, fmt.Errorf("unexpected dns response")
+	}
+	if len(resp.Questions) > 0 && !strings.EqualFold(canonicalDNSName(resp.Questions[0].Name), canonicalDNSName(query.Questions[0].Name)) {
+		return nil, fmt.Errorf("dns response for another question")
+	}
+	return resp, nil
+}
+
+func (r *dnsResolver) exchangeUDP(ctx context.Context, address string, packed []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write(packed); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(packed)
+	buf := make([]byte, dnsMaxUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray datagrams that do not answer our query
+		if n >= dnsHeaderLen && binary.BigEndian.Uint16(buf) == id {
+			return buf[:n], nil
+		}
+	}
+}
+
+// exchangeStream sends a length prefixed message over TCP or TLS
+func (r *dnsResolver) exchangeStream(ctx context.Context, transport string, server dnsServer, packed []byte) ([]byte, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if transport == dnsTransportTLS {
+		tlsConfig := &tls.Config{}
+		if r.tlsConfig != nil {
+			tlsConfig = r.tlsConfig.Clone()
+		}
+		tlsConfig.ServerName = server.serverName
+		d := &tls.Dialer{Config: tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", server.address)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", server.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	return exchangeDNSStream(conn, packed)
+}
+
+// exchangeDNSStream writes a DNS message with its two byte length prefix and reads the answer
+func exchangeDNSStream(conn io.ReadWriter, packed []byte) ([]byte, error) {
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func (r *dnsResolver) exchangeHTTPS(ctx context.Context, endpoint string, packed []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageContentType)
+	req.Header.Set("Accept", dnsMessageContentType)
+
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server answered HTTP %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, dnsMessageContentType) {
+		return nil, fmt.Errorf("DoH server answered with content type %q", contentType)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
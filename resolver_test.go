@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDNSAnswer answers home.example.com. with fixed A and AAAA records and NXDOMAIN for anything else
+func fakeDNSAnswer(t *testing.T, raw []byte, truncate bool) []byte {
+	query, err := unpackDNSMessage(raw)
+	if err != nil {
+		t.Errorf("stand-in server got an invalid query: %v", err)
+		return nil
+	}
+	resp := &dnsMessage{ID: query.ID, Flags: dnsFlagResponse | dnsFlagRecursion, Questions: query.Questions}
+	q := query.Questions[0]
+	switch {
+	case canonicalDNSName(q.Name) != "home.example.com.":
+		resp.Flags |= dnsRcodeNXDomain
+	case truncate:
+		resp.Flags |= dnsFlagTruncated
+	case q.Type == dnsTypeA:
+		resp.Answers = []dnsRR{{Name: q.Name, Type: dnsTypeA, Class: dnsClassINET, TTL: 60, Data: net.ParseIP("203.0.113.10").To4()}}
+	case q.Type == dnsTypeAAAA:
+		resp.Answers = []dnsRR{{Name: q.Name, Type: dnsTypeAAAA, Class: dnsClassINET, TTL: 60, Data: net.ParseIP("2001:db8::10")}}
+	}
+	packed, _ := resp.pack()
+	return packed
+}
+
+func startFakeUDPServer(t *testing.T, truncate bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen on udp: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(fakeDNSAnswer(t, buf[:n], truncate), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func serveFakeDNSStream(t *testing.T, l net.Listener) {
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(conn, length[:]); err != nil {
+						return
+					}
+					raw := make([]byte, int(length[0])<<8|int(length[1]))
+					if _, err := io.ReadFull(conn, raw); err != nil {
+						return
+					}
+					answer := fakeDNSAnswer(t, raw, false)
+					_, _ = conn.Write(append([]byte{byte(len(answer) >> 8), byte(len(answer))}, answer...))
+				}
+			}(conn)
+		}
+	}()
+}
+
+func sortedIPs(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func TestResolverTransports(t *testing.T) {
+	udpAddress := startFakeUDPServer(t, false)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen on tcp: %v", err)
+	}
+	serveFakeDNSStream(t, tcpListener)
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		raw, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", dnsMessageContentType)
+		_, _ = w.Write(fakeDNSAnswer(t, raw, false))
+	}))
+	defer doh.Close()
+
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: doh.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("can not listen on tls: %v", err)
+	}
+	serveFakeDNSStream(t, tlsListener)
+	tlsConfig := doh.Client().Transport.(*http.Transport).TLSClientConfig
+
+	testCases := map[string]string{
+		"udp":   "udp://" + udpAddress,
+		"bare":  udpAddress,
+		"tcp":   "tcp://" + tcpListener.Addr().String(),
+		"dot":   "tls://" + tlsListener.Addr().String() + "?servername=example.com",
+		"doh":   doh.URL + "/dns-query",
+		"retry": "udp://127.0.0.1:1, " + "tcp://" + tcpListener.Addr().String(),
+	}
+
+	for name, spec := range testCases {
+		r, err := newDNSResolver(strings.Split(spec, ","), time.Second, tlsConfig)
+		if err != nil {
+			t.Errorf("%s: newDNSResolver failed: %v", name, err)
+			continue
+		}
+		r.httpClient = doh.Client
+
+		ips, err := r.LookupIP(context.Background(), "home.example.com")
+		if err != nil {
+			t.Errorf("%s: lookup failed: %v", name, err)
+			continue
+		}
+		if actual := sortedIPs(ips); actual != "2001:db8::10,203.0.113.10" {
+			t.Errorf("%s: expected both records but got %s", name, actual)
+		}
+
+		if _, err := r.LookupIP(context.Background(), "missing.example.com"); err != errNoSuchHost {
+			t.Errorf("%s: expected no such host but got %v", name, err)
+		}
+	}
+}
+
+func TestResolverTruncatedFallsBackToTCP(t *testing.T) {
+	// The UDP server only answers truncated responses, the TCP one on the same port answers in full
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen on tcp: %v", err)
+	}
+	serveFakeDNSStream(t, tcpListener)
+
+	truncating, err := net.ListenPacket("udp", tcpListener.Addr().String())
+	if err != nil {
+		t.Skipf("port of the tcp listener is taken on udp: %v", err)
+	}
+	t.Cleanup(func() { _ = truncating.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := truncating.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = truncating.WriteTo(fakeDNSAnswer(t, buf[:n], true), addr)
+		}
+	}()
+
+	r, err := newDNSResolver([]string{tcpListener.Addr().String()}, time.Second, nil)
+	if err != nil {
+		t.Fatalf("newDNSResolver failed: %v", err)
+	}
+	ips, err := r.LookupIP(context.Background(), "home.example.com")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if actual := sortedIPs(ips); actual != "2001:db8::10,203.0.113.10" {
+		t.Errorf("expected both records over tcp but got %s", actual)
+	}
+}
+
+func TestParseDNSServerInvalid(t *testing.T) {
+	for _, spec := range []string{"ftp://1.1.1.1", "tls://", "https://"} {
+		if _, err := parseDNSServer(spec); err == nil {
+			t.Errorf("parseDNSServer(%q) expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// serverLogWriters holds the pipe behind the ErrorLog of each server built by
+// newServer until shutdownServers closes it
+var serverLogWriters sync.Map
+
+// newServer builds an http.Server with the timeouts and limits from the server config
+func newServer(addr string, handler http.Handler) *http.Server {
+	errorLog := getLogger().WriterLevel(logrus.WarnLevel)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          log.New(errorLog, "", 0),
+	}
+	serverLogWriters.Store(srv, errorLog)
+	return srv
+}
+
+// closeServerLog closes the ErrorLog pipe of srv and stops its reader
+func closeServerLog(srv *http.Server) {
+	if w, ok := serverLogWriters.LoadAndDelete(srv); ok {
+		_ = w.(io.Closer).Close()
+	}
+}
+
+// serve runs the main listener (and the http to https redirect listener when
+// enabled) until the main listener fails or SIGINT/SIGTERM is received. On a
+// signal both listeners stop accepting connections and in-flight requests get
+// cfg.ShutdownTimeout to finish before their connections are closed.
+func serve(handler http.Handler) error {
+	mainServer := newServer(cfg.HostName+":"+strconv.Itoa(cfg.Port), handler)
+	servers := []*http.Server{mainServer}
+
+	mainErr := make(chan error, 1)
+	go func() {
+		getLogger().Infof("Starting server on port %s...\n", mainServer.Addr)
+		if cfg.SSL {
+			mainErr <- mainServer.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		} else {
+			mainErr <- mainServer.ListenAndServe()
+		}
+	}()
+
+	if cfg.SSL && cfg.redirectHttp > 0 {
+		redirectServer := newServer(cfg.HostName+":"+strconv.Itoa(cfg.redirectHttp), http.HandlerFunc(redirectHandler))
+		servers = append(servers, redirectServer)
+		go func() {
+			err := redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				getLogger().Errorf("Can not redirect http to https on port %d: %v", cfg.redirectHttp, err)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	var err error
+	select {
+	case err = <-mainErr:
+	case sig := <-stop:
+		getLogger().Infof("Received %s, shutting down (waiting up to %s for in-flight requests)", sig, cfg.ShutdownTimeout)
+	}
+
+	shutdownServers(servers)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+func shutdownServers(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			defer closeServerLog(srv)
+			if err := srv.Shutdown(ctx); err != nil {
+				getLogger().Warnf("Server %s did not drain in time, closing connections: %v", srv.Addr, err)
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	getLogger().Info("Server stopped")
+}
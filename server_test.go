@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServer(t *testing.T) {
+	savedCfg := cfg
+	cfg = &ServerConfig{
+		ReadTimeout:       11 * time.Second,
+		ReadHeaderTimeout: 3 * time.Second,
+		WriteTimeout:      13 * time.Second,
+		IdleTimeout:       90 * time.Second,
+		MaxHeaderBytes:    8192,
+	}
+	defer func() { cfg = savedCfg }()
+
+	srv := newServer("127.0.0.1:9004", http.NotFoundHandler())
+	defer closeServerLog(srv)
+	if srv.Addr != "127.0.0.1:9004" || srv.Handler == nil || srv.ErrorLog == nil {
+		t.Errorf("unexpected server %+v", srv)
+	}
+	if srv.ReadTimeout != cfg.ReadTimeout || srv.ReadHeaderTimeout != cfg.ReadHeaderTimeout ||
+		srv.WriteTimeout != cfg.WriteTimeout || srv.IdleTimeout != cfg.IdleTimeout || srv.MaxHeaderBytes != cfg.MaxHeaderBytes {
+		t.Errorf("server limits do not match the config: %+v", srv)
+	}
+}
+
+// startTestServer serves handler on a local port and returns the server and its URL
+func startTestServer(t *testing.T, handler http.Handler) (*http.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	srv := newServer(ln.Addr().String(), handler)
+	go func() { _ = srv.Serve(ln) }()
+	return srv, "http://" + ln.Addr().String() + "/"
+}
+
+func TestShutdownServersDrains(t *testing.T) {
+	savedCfg := cfg
+	cfg = &ServerConfig{ShutdownTimeout: 5 * time.Second}
+	defer func() { cfg = savedCfg }()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	srv, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}))
+
+	answer := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			answer <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		answer <- string(body)
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdownServers([]*http.Server{srv})
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("shutdown returned before the in-flight request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if body := <-answer; body != "done" {
+		t.Errorf("in-flight request expected done but got %q", body)
+	}
+	<-stopped
+	if _, err := http.Get(url); err == nil {
+		t.Errorf("a stopped server must not accept requests")
+	}
+}
+
+func TestShutdownServersTimeout(t *testing.T) {
+	savedCfg := cfg
+	cfg = &ServerConfig{ShutdownTimeout: 50 * time.Millisecond}
+	defer func() { cfg = savedCfg }()
+
+	started := make(chan struct{})
+	srv, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	failed := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		failed <- err
+	}()
+	<-started
+
+	begin := time.Now()
+	shutdownServers([]*http.Server{srv})
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s despite the timeout", elapsed)
+	}
+	if err := <-failed; err == nil {
+		t.Errorf("the stuck request must be cut off")
+	}
+	if _, ok := serverLogWriters.Load(srv); ok {
+		t.Errorf("the error log of a stopped server must be closed")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateStore keeps small values that should survive a restart, like the zone
+// and record IDs providers looked up. Values are JSON encoded and kept in
+// memory; with a state file they are written to it periodically and on
+// shutdown. Without one the state lives only as long as the process.
+type stateStore struct {
+	mu        sync.Mutex
+	path      string
+	entries   map[string]stateEntry
+	dirty     bool
+	lastFlush time.Time
+	flushErr  error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type stateEntry struct {
+	Value   json.RawMessage `json:"value"`
+	Expires time.Time       `json:"expires,omitempty"`
+}
+
+var state = newStateStore("")
+
+// getState returns the state store of the process
+func getState() *stateStore {
+	return state
+}
+
+func newStateStore(path string) *stateStore {
+	return &stateStore{path: path, entries: make(map[string]stateEntry)}
+}
+
+// openStateStore loads the state file of the configuration and starts
+// flushing it every StateFlushInterval
+func openStateStore(c *ServerConfig) error {
+	s := newStateStore(c.StateFile)
+	if s.path != "" {
+		data, err := os.ReadFile(s.path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &s.entries); err != nil {
+				return fmt.Errorf("invalid state file %s: %v", s.path, err)
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.flushLoop(c.StateFlushInterval)
+	}
+	state = s
+	return nil
+}
+
+// closeStateStore stops the periodic flush and writes the state file a last time
+func closeStateStore() {
+	s := state
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	if err := s.Flush(); err != nil {
+		getLogger().Errorf("Can not write state file %s: %v", s.path, err)
+	}
+}
+
+func (s *stateStore) flushLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				getLogger().Warnf("Can not write state file %s: %v", s.path, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Get decodes the value of key into v and reports whether it was found
+func (s *stateStore) Get(key string, v interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		delete(s.entries, key)
+		s.dirty = true
+		return false
+	}
+	return json.Unmarshal(entry.Value, v) == nil
+}
+
+// Set stores v under key; a ttl of 0 keeps it until it is deleted
+func (s *stateStore) Set(key string, v interface{}, ttl time.Duration) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		getLogger().Warnf("Can not store state %s: %v", key, err)
+		return
+	}
+	entry := stateEntry{Value: value}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	s.dirty = true
+}
+
+// Delete removes key
+func (s *stateStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; ok {
+		delete(s.entries, key)
+		s.dirty = true
+	}
+}
+
+// DeletePrefix removes every key starting with prefix
+func (s *stateStore) DeletePrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.entries, key)
+			s.dirty = true
+		}
+	}
+}
+
+// Flush writes the state file if anything changed since the last write. The
+// file is replaced atomically so a crash never leaves a truncated state.
+func (s *stateStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+	now := time.Now()
+	for key, entry := range s.entries {
+		if !entry.Expires.IsZero() && now.After(entry.Expires) {
+			delete(s.entries, key)
+		}
+	}
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data, 0600)
+	}
+	s.flushErr = err
+	if err == nil {
+		s.dirty = false
+		s.lastFlush = now
+	}
+	return err
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkStateStore reports whether the state file can be written
+func checkStateStore(_ context.Context) (string, error) {
+	s := getState()
+	if s.path == "" {
+		return "in memory only", errCheckSkipped
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flushErr != nil {
+		return "", fmt.Errorf("last write of %s failed: %v", s.path, s.flushErr)
+	}
+	if info, err := os.Stat(filepath.Dir(s.path)); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("state directory of %s is not usable", s.path)
+	}
+	message := fmt.Sprintf("%d entries", len(s.entries))
+	if !s.lastFlush.IsZero() {
+		message += ", written " + s.lastFlush.UTC().Format(time.RFC3339)
+	}
+	return message, nil
+}
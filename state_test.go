@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStorePersists(t *testing.T) {
+	previous := state
+	t.Cleanup(func() { state = previous })
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := openStateStore(&ServerConfig{StateFile: path, StateFlushInterval: time.Hour}); err != nil {
+		t.Fatalf("openStateStore failed: %v", err)
+	}
+	getState().Set("cloudflare/zone/home.example.com", "zone-1", time.Hour)
+	getState().Set("expired", "x", time.Nanosecond)
+	getState().Set("deleted", "x", 0)
+	getState().Delete("deleted")
+	closeStateStore()
+
+	if err := openStateStore(&ServerConfig{StateFile: path, StateFlushInterval: time.Hour}); err != nil {
+		t.Fatalf("reopening the state store failed: %v", err)
+	}
+	defer closeStateStore()
+
+	var zoneID string
+	if !getState().Get("cloudflare/zone/home.example.com", &zoneID) || zoneID != "zone-1" {
+		t.Errorf("state was not restored, got %q", zoneID)
+	}
+	for _, key := range []string{"expired", "deleted"} {
+		var value string
+		if getState().Get(key, &value) {
+			t.Errorf("%s entry survived the restart", key)
+		}
+	}
+	if _, err := checkStateStore(context.Background()); err != nil {
+		t.Errorf("state readiness check failed: %v", err)
+	}
+}
@@ -1,19 +1,331 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// Interpolate replaces placeholders in a string with values from a map
+// Templates are strings with {name} placeholders, optionally followed by
+// filters: {ddpass|userinfo}, {host|path}, {ip|json}, {token|base64} or
+// {zone|default:example.org}. Filters are applied left to right. {{ and }}
+// stand for literal braces; a brace that does not start a valid placeholder is
+// kept as is. Placeholders without a value and without a default are left
+// unchanged.
+//
+// URL templates escape each placeholder for the part of the URL it appears in
+// (userinfo, path, query or fragment) unless an escaping filter is given;
+// placeholders in the scheme and host are inserted raw.
+
+// templateFilter transforms a placeholder value; escaping filters replace the
+// automatic escaping of URL templates
+type templateFilter struct {
+	apply    func(value, arg string) string
+	escapes  bool
+	takesArg bool
+}
+
+var templateFilters = map[string]templateFilter{
+	"default": {apply: func(value, arg string) string {
+		if value == "" {
+			return arg
+		}
+		return value
+	}, takesArg: true},
+	"raw":      {apply: func(value, _ string) string { return value }, escapes: true},
+	"query":    {apply: func(value, _ string) string { return url.QueryEscape(value) }, escapes: true},
+	"path":     {apply: func(value, _ string) string { return url.PathEscape(value) }, escapes: true},
+	"userinfo": {apply: func(value, _ string) string { return escapeUserinfo(value) }, escapes: true},
+	"json": {apply: func(value, _ string) string {
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}, escapes: true},
+	"base64": {apply: func(value, _ string) string { return base64.StdEncoding.EncodeToString([]byte(value)) }, escapes: true},
+}
+
+// escapeUserinfo escapes a user name or password for the userinfo part of a URL
+func escapeUserinfo(value string) string {
+	return url.User(value).String()
+}
+
+// templateContext is the part of a URL a placeholder is in
+type templateContext int
+
+const (
+	templateContextText templateContext = iota
+	templateContextUserinfo
+	templateContextHost
+	templateContextPath
+	templateContextQuery
+	templateContextFragment
+)
+
+var templateContextEscapers = map[templateContext]func(string) string{
+	templateContextUserinfo: escapeUserinfo,
+	templateContextPath:     url.PathEscape,
+	templateContextQuery:    url.QueryEscape,
+	templateContextFragment: url.PathEscape,
+}
+
+type templateFilterCall struct {
+	filter templateFilter
+	arg    string
+}
+
+type templatePlaceholder struct {
+	name       string
+	source     string
+	filters    []templateFilterCall
+	hasDefault bool
+	escaped    bool
+	context    templateContext
+}
+
+type templateSegment struct {
+	literal     string
+	placeholder *templatePlaceholder
+}
+
+// compiledTemplate is a parsed template, safe for concurrent use
+type compiledTemplate struct {
+	segments []templateSegment
+}
+
+type templateCacheKey struct {
+	format string
+	url    bool
+}
+
+// templateCacheLimit bounds the cached templates. Templates come from the
+// configuration, so the limit is only reached when arbitrary strings are
+// interpolated; those are then parsed on every call.
+const templateCacheLimit = 1024
+
+var (
+	templateCache     sync.Map
+	templateCacheSize atomic.Int64
+)
+
+// compileTemplate parses a text template; compiled templates are cached
+func compileTemplate(format string) (*compiledTemplate, error) {
+	return cachedTemplate(templateCacheKey{format: format})
+}
+
+// compileURLTemplate parses a URL template, escaping placeholders by their position in the URL
+func compileURLTemplate(format string) (*compiledTemplate, error) {
+	return cachedTemplate(templateCacheKey{format: format, url: true})
+}
+
+func cachedTemplate(key templateCacheKey) (*compiledTemplate, error) {
+	if t, ok := templateCache.Load(key); ok {
+		return t.(*compiledTemplate), nil
+	}
+	t, err := parseTemplate(key.format)
+	if err != nil {
+		return nil, err
+	}
+	if key.url {
+		t.assignURLContexts()
+	}
+	if templateCacheSize.Load() >= templateCacheLimit {
+		return t, nil
+	}
+	actual, loaded := templateCache.LoadOrStore(key, t)
+	if !loaded {
+		templateCacheSize.Add(1)
+	}
+	return actual.(*compiledTemplate), nil
+}
+
+func parseTemplate(format string) (*compiledTemplate, error) {
+	t := &compiledTemplate{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.segments = append(t.segments, templateSegment{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if (c == '{' || c == '}') && i+1 < len(format) && format[i+1] == c {
+			literal.WriteByte(c)
+			i++
+			continue
+		}
+		if c == '{' {
+			end := strings.IndexByte(format[i+1:], '}')
+			if end >= 0 {
+				p, err := parsePlaceholder(format[i : i+end+2])
+				if err != nil {
+					return nil, err
+				}
+				if p != nil {
+					flush()
+					t.segments = append(t.segments, templateSegment{placeholder: p})
+					i += end + 1
+					continue
+				}
+			}
+		}
+		literal.WriteByte(c)
+	}
+	flush()
+	return t, nil
+}
+
+// parsePlaceholder parses "{name|filter|filter:arg}"; it returns nil if source
+// is not a placeholder and an error if it uses an unknown filter
+func parsePlaceholder(source string) (*templatePlaceholder, error) {
+	parts := strings.Split(source[1:len(source)-1], "|")
+	if !isPlaceholderName(parts[0]) {
+		return nil, nil
+	}
+	p := &templatePlaceholder{name: parts[0], source: source}
+	for _, part := range parts[1:] {
+		name, arg, hasArg := strings.Cut(part, ":")
+		filter, ok := templateFilters[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q in placeholder %s", name, p.name)
+		}
+		if hasArg && !filter.takesArg {
+			return nil, fmt.Errorf("filter %q takes no argument in placeholder %s", name, p.name)
+		}
+		p.filters = append(p.filters, templateFilterCall{filter: filter, arg: arg})
+		p.hasDefault = p.hasDefault || filter.takesArg
+		p.escaped = p.escaped || filter.escapes
+	}
+	return p, nil
+}
+
+func isPlaceholderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case i > 0 && (r == '-' || r == '.' || (r >= '0' && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// assignURLContexts follows the literal text of a URL template to find the
+// part of the URL each placeholder is in
+func (t *compiledTemplate) assignURLContexts() {
+	const (
+		inScheme = iota
+		inAuthority
+		inPath
+		inQuery
+		inFragment
+	)
+	state := inScheme
+	var authority []*templatePlaceholder
+	for _, segment := range t.segments {
+		if p := segment.placeholder; p != nil {
+			switch state {
+			case inScheme:
+				p.context = templateContextText
+			case inAuthority:
+				p.context = templateContextHost
+				authority = append(authority, p)
+			case inPath:
+				p.context = templateContextPath
+			case inQuery:
+				p.context = templateContextQuery
+			case inFragment:
+				p.context = templateContextFragment
+			}
+			continue
+		}
+		s := segment.literal
+		for i := 0; i < len(s); i++ {
+			switch c := s[i]; {
+			case state == inScheme && strings.HasPrefix(s[i:], "://"):
+				state = inAuthority
+				i += 2
+			case c == '@' && state == inAuthority:
+				// everything so far in the authority was the userinfo
+				for _, p := range authority {
+					p.context = templateContextUserinfo
+				}
+				authority = nil
+			case c == '/' && (state == inScheme || state == inAuthority):
+				state = inPath
+			case c == '?' && state < inQuery:
+				state = inQuery
+			case c == '#' && state < inFragment:
+				state = inFragment
+			}
+		}
+	}
+}
+
+// Execute fills the template with values from data
+func (t *compiledTemplate) Execute(data map[string]interface{}) string {
+	var result strings.Builder
+	for _, segment := range t.segments {
+		p := segment.placeholder
+		if p == nil {
+			result.WriteString(segment.literal)
+			continue
+		}
+		raw, ok := data[p.name]
+		if !ok && !p.hasDefault {
+			result.WriteString(p.source)
+			continue
+		}
+		value := ""
+		if ok && raw != nil {
+			value = fmt.Sprintf("%v", raw)
+		}
+		for _, call := range p.filters {
+			value = call.filter.apply(value, call.arg)
+		}
+		if escape := templateContextEscapers[p.context]; escape != nil && !p.escaped {
+			value = escape(value)
+		}
+		result.WriteString(value)
+	}
+	return result.String()
+}
+
+// Placeholders returns the names of the placeholders used in a template, in order of their first appearance
+func (t *compiledTemplate) Placeholders() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, segment := range t.segments {
+		if p := segment.placeholder; p != nil && !seen[p.name] {
+			seen[p.name] = true
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// Placeholders returns the distinct placeholder names of a format string in order of appearance
+func Placeholders(format string) []string {
+	t, err := compileTemplate(format)
+	if err != nil {
+		return nil
+	}
+	return t.Placeholders()
+}
+
+// Interpolate replaces placeholders in a string with values from a map; a
+// format that does not compile is returned unchanged
 func Interpolate(format string, data map[string]interface{}) string {
-	re := regexp.MustCompile(`{([^}]+)}`)
-	result := re.ReplaceAllStringFunc(format, func(match string) string {
-		key := match[1 : len(match)-1] // Remove curly braces
-		if value, ok := data[key]; ok {
-			return fmt.Sprintf("%v", value)
-		}
-		return match // Placeholder not found in map, return unchanged
-	})
-	return result
+	t, err := compileTemplate(format)
+	if err != nil {
+		return format
+	}
+	return t.Execute(data)
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	varTypeIP       = "ip"
+	varTypeIPv4     = "ipv4"
+	varTypeIPv6     = "ipv6"
+	varTypeHostname = "hostname"
+	varTypeInt      = "int"
+	varTypeEnum     = "enum"
+)
+
+// errBadUpdateRequest wraps errors caused by the client request rather than the provider
+var errBadUpdateRequest = errors.New("bad update request")
+
+var hostnameRegexp = regexp.MustCompile(`^(?i)([a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?\.)*[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?\.?$`)
+
+// templateVar is a variable a URL template accepts from the request, declared
+// in the "params" object of a credential entry:
+//
+//	"params": {
+//	    "ttl":  {"type": "int", "default": "300", "min": 60, "max": 86400},
+//	    "zone": {"type": "enum", "values": ["a.example.com", "b.example.com"], "required": true}
+//	}
+type templateVar struct {
+	Name     string
+	Type     string
+	Required bool
+	Default  string
+	Values   []string
+	Min      *int64
+	Max      *int64
+}
+
+// templateVars are the declared variables of a template, by name
+type templateVars map[string]*templateVar
+
+// parseTemplateVars reads the "params" object of a credential entry. Names in
+// reserved are filled by the provider and can not be declared.
+func parseTemplateVars(entry gjson.Result, reserved []string) (templateVars, error) {
+	vars := make(templateVars)
+	var err error
+	entry.Get("params").ForEach(func(key, value gjson.Result) bool {
+		v := &templateVar{
+			Name:     key.String(),
+			Type:     strings.ToLower(value.Get("type").String()),
+			Required: value.Get("required").Bool(),
+			Default:  value.Get("default").String(),
+		}
+		for _, name := range reserved {
+			if v.Name == name {
+				err = fmt.Errorf("param %q is reserved", v.Name)
+				return false
+			}
+		}
+		switch v.Type {
+		case varTypeIP, varTypeIPv4, varTypeIPv6, varTypeHostname:
+		case varTypeInt:
+			if value.Get("min").Exists() {
+				lo := value.Get("min").Int()
+				v.Min = &lo
+			}
+			if value.Get("max").Exists() {
+				hi := value.Get("max").Int()
+				v.Max = &hi
+			}
+		case varTypeEnum:
+			for _, allowed := range value.Get("values").Array() {
+				v.Values = append(v.Values, allowed.String())
+			}
+			if len(v.Values) == 0 {
+				err = fmt.Errorf("enum param %q has no values", v.Name)
+				return false
+			}
+		default:
+			err = fmt.Errorf("param %q has unknown type %q", v.Name, v.Type)
+			return false
+		}
+		if v.Default != "" {
+			if _, defaultErr := v.validate(v.Default); defaultErr != nil {
+				err = fmt.Errorf("default of param %q: %v", v.Name, defaultErr)
+				return false
+			}
+		}
+		vars[v.Name] = v
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// validate checks raw against the type of the variable and returns it normalized
+func (v *templateVar) validate(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch v.Type {
+	case varTypeIP, varTypeIPv4, varTypeIPv6:
+		ip := net.ParseIP(raw)
+		if ip == nil || (v.Type == varTypeIPv4 && ip.To4() == nil) || (v.Type == varTypeIPv6 && ip.To4() != nil) {
+			return "", fmt.Errorf("%q is not a valid %s address", raw, v.Type)
+		}
+		return ip.String(), nil
+	case varTypeHostname:
+		if len(raw) > 253 || !hostnameRegexp.MatchString(raw) {
+			return "", fmt.Errorf("%q is not a valid hostname", raw)
+		}
+		return strings.ToLower(raw), nil
+	case varTypeInt:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", raw)
+		}
+		if (v.Min != nil && n < *v.Min) || (v.Max != nil && n > *v.Max) {
+			return "", fmt.Errorf("%d is out of range", n)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case varTypeEnum:
+		for _, allowed := range v.Values {
+			if raw == allowed {
+				return raw, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", raw, strings.Join(v.Values, ", "))
+	}
+	return "", fmt.Errorf("unknown type %q", v.Type)
+}
+
+// resolve validates the declared variables against the request parameters and
+// returns their values; undeclared parameters are ignored.
+func (vars templateVars) resolve(params map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		raw := ""
+		if value, ok := params[name]; ok {
+			raw = fmt.Sprint(value)
+		}
+		if raw == "" {
+			if v.Required {
+				return nil, fmt.Errorf("%w: param %s is required", errBadUpdateRequest, name)
+			}
+			if v.Default == "" {
+				values[name] = ""
+				continue
+			}
+			raw = v.Default
+		}
+		value, err := v.validate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: param %s: %v", errBadUpdateRequest, name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// checkPlaceholders reports placeholders of template that are neither filled
+// by the provider (builtins) nor declared as variables.
+func (vars templateVars) checkPlaceholders(template string, builtins []string) error {
+	known := make(map[string]bool, len(builtins)+len(vars))
+	for _, name := range builtins {
+		known[name] = true
+	}
+	for name := range vars {
+		known[name] = true
+	}
+	t, err := compileTemplate(template)
+	if err != nil {
+		return fmt.Errorf("template %q: %v", redact(template), err)
+	}
+	var unknown []string
+	for _, name := range t.Placeholders() {
+		if !known[name] {
+			unknown = append(unknown, "{"+name+"}")
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown placeholders %s in template %q", strings.Join(unknown, ", "), redact(template))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTemplateVarsValidate(t *testing.T) {
+	vars, err := parseTemplateVars(gjson.Parse(`{"params": {
+		"ttl":  {"type": "int", "default": "300", "min": 60, "max": 86400},
+		"zone": {"type": "enum", "values": ["a.example.com", "b.example.com"], "required": true},
+		"peer": {"type": "ipv4"},
+		"name": {"type": "hostname"}
+	}}`), urlProviderBuiltins)
+	if err != nil {
+		t.Fatalf("parseTemplateVars failed: %v", err)
+	}
+
+	testCases := []map[string]interface{}{
+		{"params": map[string]interface{}{"zone": "a.example.com"}, "valid": true},
+		{"params": map[string]interface{}{"zone": "a.example.com", "ttl": "120", "peer": "203.0.113.1", "name": "Home.Example.com"}, "valid": true},
+		{"params": map[string]interface{}{}, "valid": false},
+		{"params": map[string]interface{}{"zone": "c.example.com"}, "valid": false},
+		{"params": map[string]interface{}{"zone": "a.example.com", "ttl": "10"}, "valid": false},
+		{"params": map[string]interface{}{"zone": "a.example.com", "ttl": "300&x=1"}, "valid": false},
+		{"params": map[string]interface{}{"zone": "a.example.com", "peer": "2001:db8::1"}, "valid": false},
+		{"params": map[string]interface{}{"zone": "a.example.com", "name": "{ddpass}"}, "valid": false},
+	}
+
+	for _, testCase := range testCases {
+		values, err := vars.resolve(testCase["params"].(map[string]interface{}))
+		if (err == nil) != testCase["valid"].(bool) {
+			t.Errorf("resolve(%v) expected valid=%v but got %v", testCase["params"], testCase["valid"], err)
+		}
+		if err != nil && !errors.Is(err, errBadUpdateRequest) {
+			t.Errorf("resolve(%v) error is not a bad request: %v", testCase["params"], err)
+		}
+		if err == nil && values["ttl"] == "" {
+			t.Errorf("resolve(%v) did not apply the default ttl", testCase["params"])
+		}
+	}
+}
+
+func TestTemplateVarsUnknownPlaceholders(t *testing.T) {
+	testCases := map[string]bool{
+		`{"url": "https://example.com/update?host={ddhost}&ip={ddip}"}`:                                           true,
+		`{"url": "https://example.com/update?host={ddhost}&ttl={ttl}", "params": {"ttl": {"type": "int"}}}`:       true,
+		`{"url": "https://example.com/update?host={ddhost}&ttl={ttl}"}`:                                           false,
+		`{"url": "https://example.com/update?host={ddhost}", "params": {"ddhost": {"type": "hostname"}}}`:         false,
+		`{"url": "https://example.com/update?host={ddhost}", "params": {"mode": {"type": "string"}}}`:             false,
+		`{"url": "https://example.com/update?host={ddhost}", "params": {"mode": {"type": "enum", "values": []}}}`: false,
+	}
+
+	for entry, valid := range testCases {
+		if _, err := newURLProvider("user", gjson.Parse(entry)); (err == nil) != valid {
+			t.Errorf("newURLProvider(%s) expected valid=%v but got %v", entry, valid, err)
+		}
+	}
+}
+
+func TestURLProviderIgnoresUndeclaredParams(t *testing.T) {
+	var query string
+	ddns := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		_, _ = w.Write([]byte("good"))
+	}))
+	defer ddns.Close()
+
+	provider, err := newURLProvider("user", gjson.Parse(`{
+		"url": "`+ddns.URL+`/update?hostname={ddhost}&myip={ddip}&ttl={ttl}",
+		"params": {"ttl": {"type": "int", "default": "300"}}
+	}`))
+	if err != nil {
+		t.Fatalf("newURLProvider failed: %v", err)
+	}
+	allowPrivate := true
+	policy, _ := parseOutboundPolicy(defaultOutboundPolicy, "", "", &allowPrivate)
+
+	_, err = provider.Update(withOutboundPolicy(context.Background(), policy), &UpdateRequest{
+		Host:   "home.example.com",
+		IPs:    []net.IP{net.ParseIP("203.0.113.7")},
+		Creds:  &UserInfo{DDUser: "u", DDPass: "p"},
+		Params: map[string]interface{}{"ttl": "600", "ddpass": "x", "extra": "{ddpass}"},
+	})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if query != "hostname=home.example.com&myip=203.0.113.7&ttl=600" {
+		t.Errorf("unexpected provider query: %s", query)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG (RFC 8945) algorithms
+const (
+	tsigHMACSHA256 = "hmac-sha256."
+	tsigHMACSHA512 = "hmac-sha512."
+
+	tsigFudge = 300
+
+	tsigErrBadSig  = 16
+	tsigErrBadKey  = 17
+	tsigErrBadTime = 18
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	tsigHMACSHA256: sha256.New,
+	tsigHMACSHA512: sha512.New,
+}
+
+var tsigErrorNames = map[uint16]string{
+	tsigErrBadSig:  "BADSIG",
+	tsigErrBadKey:  "BADKEY",
+	tsigErrBadTime: "BADTIME",
+}
+
+// errTSIGVerify means the answer of the server was not signed with our key
+var errTSIGVerify = errors.New("tsig verification failed")
+
+// tsigKey is a shared TSIG secret
+type tsigKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// newTSIGKey parses a key as written in BIND and Knot configurations: the
+// algorithm name with or without "hmac-" and the base64 secret
+func newTSIGKey(name, algorithm, secret string) (*tsigKey, error) {
+	algorithm = canonicalDNSName(algorithm)
+	if !strings.HasPrefix(algorithm, "hmac-") {
+		algorithm = "hmac-" + algorithm
+	}
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported tsig algorithm %q, use hmac-sha256 or hmac-sha512", strings.TrimSuffix(algorithm, "."))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("tsig secret must be base64")
+	}
+	return &tsigKey{Name: canonicalDNSName(name), Algorithm: algorithm, Secret: decoded}, nil
+}
+
+// tsigRecord is the RDATA of a TSIG record
+type tsigRecord struct {
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	Other      []byte
+}
+
+func (t *tsigRecord) pack() ([]byte, error) {
+	b, err := appendDNSName(nil, t.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint48(b, t.TimeSigned)
+	b = binary.BigEndian.AppendUint16(b, t.Fudge)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.MAC)))
+	b = append(b, t.MAC...)
+	b = binary.BigEndian.AppendUint16(b, t.OriginalID)
+	b = binary.BigEndian.AppendUint16(b, t.Error)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...), nil
+}
+
+// unpackTSIGRecord reads the RDATA of a TSIG record at off of message b
+func unpackTSIGRecord(b []byte, off int, length int) (*tsigRecord, error) {
+	end := off + length
+	algorithm, next, err := readDNSName(b, off)
+	if err != nil {
+		return nil, err
+	}
+	if next+10 > end {
+		return nil, fmt.Errorf("tsig record truncated")
+	}
+	t := &tsigRecord{Algorithm: strings.ToLower(algorithm)}
+	t.TimeSigned = uint64(binary.BigEndian.Uint16(b[next:]))<<32 | uint64(binary.BigEndian.Uint32(b[next+2:]))
+	t.Fudge = binary.BigEndian.Uint16(b[next+6:])
+	macLen := int(binary.BigEndian.Uint16(b[next+8:]))
+	next += 10
+	if next+macLen+6 > end {
+		return nil, fmt.Errorf("tsig record truncated")
+	}
+	t.MAC = b[next : next+macLen]
+	next += macLen
+	t.OriginalID = binary.BigEndian.Uint16(b[next:])
+	t.Error = binary.BigEndian.Uint16(b[next+2:])
+	otherLen := int(binary.BigEndian.Uint16(b[next+4:]))
+	if next+6+otherLen > end {
+		return nil, fmt.Errorf("tsig record truncated")
+	}
+	t.Other = b[next+6 : next+6+otherLen]
+	return t, nil
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// variables returns the TSIG variables covered by the MAC (RFC 8945 section 4.3.3)
+func (k *tsigKey) variables(t *tsigRecord) []byte {
+	b, _ := appendDNSName(nil, k.Name)
+	b = binary.BigEndian.AppendUint16(b, dnsClassANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b, _ = appendDNSName(b, t.Algorithm)
+	b = appendUint48(b, t.TimeSigned)
+	b = binary.BigEndian.AppendUint16(b, t.Fudge)
+	b = binary.BigEndian.AppendUint16(b, t.Error)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...)
+}
+
+func (k *tsigKey) mac(parts ...[]byte) []byte {
+	h := hmac.New(tsigAlgorithms[k.Algorithm], k.Secret)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// sign packs m with a TSIG record appended and returns the message and its MAC
+func (k *tsigKey) sign(m *dnsMessage, now time.Time) ([]byte, []byte, error) {
+	unsigned, err := m.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &tsigRecord{Algorithm: k.Algorithm, TimeSigned: uint64(now.Unix()), Fudge: tsigFudge, OriginalID: m.ID}
+	t.MAC = k.mac(unsigned, k.variables(t))
+	data, err := t.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	signed, err := appendDNSRR(unsigned, dnsRR{Name: k.Name, Type: dnsTypeTSIG, Class: dnsClassANY, Data: data})
+	if err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, t.MAC, nil
+}
+
+// verify checks the TSIG record of a response to a request signed with
+// requestMAC. It returns the TSIG record, nil if the response is unsigned.
+func (k *tsigKey) verify(raw []byte, requestMAC []byte, now time.Time) (*tsigRecord, error) {
+	start, rdata, rdlength, err := lastDNSRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, nil
+	}
+	name, next, err := readDNSName(raw, start)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(raw[next:]) != dnsTypeTSIG {
+		return nil, nil
+	}
+	t, err := unpackTSIGRecord(raw, rdata, rdlength)
+	if err != nil {
+		return nil, err
+	}
+	if canonicalDNSName(name) != k.Name || t.Algorithm != k.Algorithm {
+		return t, fmt.Errorf("%w: signed with another key", errTSIGVerify)
+	}
+	if len(t.MAC) == 0 {
+		// BADKEY and BADSIG answers are not signed
+		return t, nil
+	}
+
+	// The MAC covers the message as it was before the TSIG record was added
+	unsigned := append([]byte{}, raw[:start]...)
+	binary.BigEndian.PutUint16(unsigned[0:], t.OriginalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	macPrefix := binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC)))
+	expected := k.mac(macPrefix, requestMAC, unsigned, k.variables(t))
+	if !hmac.Equal(expected, t.MAC) {
+		return t, fmt.Errorf("%w: bad signature", errTSIGVerify)
+	}
+	if delta := now.Unix() - int64(t.TimeSigned); delta > int64(t.Fudge) || -delta > int64(t.Fudge) {
+		return t, fmt.Errorf("%w: signature time is off by %ds", errTSIGVerify, delta)
+	}
+	return t, nil
+}
+
+// lastDNSRecord finds the last record of the additional section of message b,
+// returning the offsets of the record and of its RDATA and the RDATA length.
+// The offset is -1 when the additional section is empty.
+func lastDNSRecord(b []byte) (start, rdata, rdlength int, err error) {
+	if len(b) < dnsHeaderLen {
+		return 0, 0, 0, fmt.Errorf("dns message too short")
+	}
+	off := dnsHeaderLen
+	for i := 0; i < int(binary.BigEndian.Uint16(b[4:])); i++ {
+		_, next, err := readDNSName(b, off)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		off = next + 4
+	}
+	records := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+	start = -1
+	if binary.BigEndian.Uint16(b[10:]) == 0 {
+		return -1, 0, 0, nil
+	}
+	for i := 0; i < records; i++ {
+		start = off
+		_, next, err := readDNSName(b, off)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if next+10 > len(b) {
+			return 0, 0, 0, fmt.Errorf("dns record truncated")
+		}
+		rdlength = int(binary.BigEndian.Uint16(b[next+8:]))
+		rdata = next + 10
+		off = rdata + rdlength
+		if off > len(b) {
+			return 0, 0, 0, fmt.Errorf("dns record data truncated")
+		}
+	}
+	return start, rdata, rdlength, nil
+}
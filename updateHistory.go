@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// updateHistoryLimit is how many results are kept for each host
+	updateHistoryLimit = 50
+	// longer provider answers are cut in the history
+	updateHistoryMessageLimit = 200
+)
+
+// updateHistoryEntry is the result of one provider for one update
+type updateHistoryEntry struct {
+	Time       time.Time  `json:"time"`
+	User       string     `json:"user"`
+	Provider   string     `json:"provider"`
+	IPs        []string   `json:"ips"`
+	Code       ResultCode `json:"code"`
+	StatusCode int        `json:"status,omitempty"`
+	Message    string     `json:"message,omitempty"`
+}
+
+// historyMu serializes the read-modify-write of the history lists
+var historyMu sync.Mutex
+
+// recordUpdateHistory appends entries to the update history of host in the
+// state store, keeping the latest updateHistoryLimit
+func recordUpdateHistory(host string, entries ...updateHistoryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	for i := range entries {
+		// redact before cutting, a cut secret would no longer be recognized
+		message := redact(strings.TrimSpace(entries[i].Message))
+		if len(message) > updateHistoryMessageLimit {
+			cut := updateHistoryMessageLimit
+			for cut > 0 && !utf8.RuneStart(message[cut]) {
+				cut--
+			}
+			message = message[:cut] + "..."
+		}
+		entries[i].Message = message
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	key := updateHistoryKey(host)
+	var history []updateHistoryEntry
+	getState().Get(key, &history)
+	history = append(history, entries...)
+	if len(history) > updateHistoryLimit {
+		history = history[len(history)-updateHistoryLimit:]
+	}
+	getState().Set(key, history, 0)
+}
+
+// updateHistory returns the recorded results of host, oldest first
+func updateHistory(host string) []updateHistoryEntry {
+	var history []updateHistoryEntry
+	getState().Get(updateHistoryKey(host), &history)
+	return history
+}
+
+func updateHistoryKey(host string) string {
+	return "history/" + strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// historyEntries returns the history entries of an update answered with
+// result or failed with err: one per provider of a multi provider, else one
+// for the provider of the entry
+func historyEntries(user, providerName string, update *UpdateRequest, result *UpdateResult, err error) []updateHistoryEntry {
+	entry := updateHistoryEntry{Time: time.Now().UTC(), User: user, Provider: providerName, IPs: update.IPStrings()}
+	if err != nil {
+		entry.Code, entry.Message = ResultServerError, err.Error()
+		return []updateHistoryEntry{entry}
+	}
+	if len(result.Providers) == 0 {
+		entry.Code, entry.StatusCode, entry.Message = result.Code, result.StatusCode, result.Body
+		return []updateHistoryEntry{entry}
+	}
+	var entries []updateHistoryEntry
+	for _, sub := range result.Providers {
+		e := entry
+		e.Provider = providerName + "/" + sub.Name
+		e.Code, e.StatusCode, e.Message = sub.Code, sub.StatusCode, sub.Body
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUpdateHistory(t *testing.T) {
+	useMemoryState(t)
+
+	update := &UpdateRequest{Host: "Home.Example.com", IPs: []net.IP{net.ParseIP("203.0.113.7")}}
+	for i := 0; i < updateHistoryLimit+5; i++ {
+		result := &UpdateResult{Code: ResultGood, StatusCode: 200, Body: fmt.Sprint("update ", i)}
+		recordUpdateHistory(update.Host, historyEntries("user", "url", update, result, nil)...)
+	}
+	history := updateHistory("home.example.com.")
+	if len(history) != updateHistoryLimit {
+		t.Fatalf("expected %d entries but got %d", updateHistoryLimit, len(history))
+	}
+	if history[0].Message != "update 5" || history[len(history)-1].Message != fmt.Sprint("update ", updateHistoryLimit+4) {
+		t.Errorf("expected the latest entries but got %q to %q", history[0].Message, history[len(history)-1].Message)
+	}
+	if entry := history[0]; entry.User != "user" || entry.Provider != "url" || entry.Code != ResultGood || strings.Join(entry.IPs, ",") != "203.0.113.7" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	result := &UpdateResult{Code: ResultGood, Providers: []namedResult{
+		{Name: "cloudflare", UpdateResult: UpdateResult{Code: ResultGood, Body: "A updated"}},
+		{Name: "duckdns", UpdateResult: UpdateResult{Code: ResultBadAuth, Body: strings.Repeat("x", 500)}},
+	}}
+	recordUpdateHistory("mirror.example.com", historyEntries("user", "multi", update, result, nil)...)
+	recordUpdateHistory("mirror.example.com", historyEntries("user", "multi", update, nil, errors.New("connection refused"))...)
+	history = updateHistory("mirror.example.com")
+	expected := []struct {
+		provider string
+		code     ResultCode
+	}{
+		{"multi/cloudflare", ResultGood},
+		{"multi/duckdns", ResultBadAuth},
+		{"multi", ResultServerError},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d entries but got %+v", len(expected), history)
+	}
+	for i, entry := range expected {
+		if history[i].Provider != entry.provider || history[i].Code != entry.code {
+			t.Errorf("entry %d expected %s %s but got %s %s", i, entry.provider, entry.code, history[i].Provider, history[i].Code)
+		}
+	}
+	if len(history[1].Message) != updateHistoryMessageLimit+3 {
+		t.Errorf("expected a cut message but got %d bytes", len(history[1].Message))
+	}
+
+	// a secret across the limit is masked as a whole, a character is not cut
+	registerSecrets("history-s3cr3t-value")
+	long := strings.Repeat("x", updateHistoryMessageLimit-15) + " history-s3cr3t-value"
+	accented := strings.Repeat("x", updateHistoryMessageLimit-1) + "é and more"
+	for _, message := range []string{long, accented} {
+		recordUpdateHistory("cut.example.com", historyEntries("user", "url", update, &UpdateResult{Code: ResultGood, Body: message}, nil)...)
+	}
+	history = updateHistory("cut.example.com")
+	if len(history) != 2 {
+		t.Fatalf("expected 2 entries but got %+v", history)
+	}
+	if strings.Contains(history[0].Message, "s3cr3t") {
+		t.Errorf("expected the secret to be masked but got %q", history[0].Message)
+	}
+	if !utf8.ValidString(history[1].Message) || history[1].Message != strings.Repeat("x", updateHistoryMessageLimit-1)+"..." {
+		t.Errorf("expected the message to be cut before the character but got %q", history[1].Message)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// UpstreamConfig holds the settings of the shared transport used for every outbound provider call
+type UpstreamConfig struct {
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	CAFile                string
+	InsecureSkipVerify    bool
+	TLSMinVersion         uint16
+	Proxy                 *ProxySettings
+	Policy                OutboundPolicy
+}
+
+var defaultUpstreamConfig = UpstreamConfig{
+	Timeout:               5 * time.Second,
+	DialTimeout:           5 * time.Second,
+	KeepAlive:             30 * time.Second,
+	TLSHandshakeTimeout:   5 * time.Second,
+	ResponseHeaderTimeout: 5 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	TLSMinVersion:         tls.VersionTLS12,
+	Policy:                defaultOutboundPolicy,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var upstreamClient *http.Client
+
+// getUpstreamClient returns the shared client for provider calls. Connections
+// are pooled and reused between updates; callers must pass the incoming
+// request context so a canceled client cancels the provider call too.
+func getUpstreamClient() *http.Client {
+	if upstreamClient == nil {
+		client, err := newUpstreamClient(&defaultUpstreamConfig)
+		if err != nil {
+			getLogger().Fatal("Can not build the upstream client: ", err)
+		}
+		upstreamClient = client
+	}
+	return upstreamClient
+}
+
+// setupUpstreamClient replaces the shared client with one built from the server config
+func setupUpstreamClient(c *ServerConfig) error {
+	client, err := newUpstreamClient(&c.Upstream)
+	if err != nil {
+		return err
+	}
+	upstreamClient = client
+	return nil
+}
+
+func newUpstreamClient(uc *UpstreamConfig) (*http.Client, error) {
+	transport, err := newUpstreamTransport(uc)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport:     &policyRoundTripper{next: transport, private: transport.Clone(), global: &uc.Policy},
+		CheckRedirect: checkOutboundRedirect(&uc.Policy),
+		Timeout:       uc.Timeout,
+	}, nil
+}
+
+func newUpstreamTransport(uc *UpstreamConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         uc.TLSMinVersion,
+		InsecureSkipVerify: uc.InsecureSkipVerify,
+	}
+	if uc.CAFile != "" {
+		pem, err := os.ReadFile(uc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read upstream CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in upstream CA file %s", uc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   uc.DialTimeout,
+		KeepAlive: uc.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 upstreamProxyFunc(uc.Proxy),
+		DialContext:           guardedDialContext(dialer, &uc.Policy, uc.Proxy),
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   uc.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: uc.ResponseHeaderTimeout,
+		IdleConnTimeout:       uc.IdleConnTimeout,
+		MaxIdleConns:          uc.MaxIdleConns,
+		MaxIdleConnsPerHost:   uc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       uc.MaxConnsPerHost,
+	}, nil
+}
+
+// upstreamTLSConfig returns a copy of the TLS settings of the shared transport
+func upstreamTLSConfig() *tls.Config {
+	transport := getUpstreamClient().Transport
+	if pt, ok := transport.(*policyRoundTripper); ok {
+		return pt.next.TLSClientConfig.Clone()
+	}
+	if t, ok := transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		return t.TLSClientConfig.Clone()
+	}
+	return nil
+}
+
+// outboundContext attaches the proxy and outbound policy of a credential entry to ctx
+func outboundContext(ctx context.Context, creds *UserInfo) context.Context {
+	return withOutboundPolicy(withUpstreamProxy(ctx, creds.Proxy), creds.Outbound)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+func TestReadUpstreamConfig(t *testing.T) {
+	settings, err := ini.Load([]byte(`
+upstream-timeout = 7s
+upstream-dial-timeout = 2s
+upstream-max-idle-conns-per-host = 4
+upstream-max-conns-per-host = 0
+upstream-insecure-skip-verify = true
+upstream-tls-min-version = 1.3
+`))
+	if err != nil {
+		t.Fatalf("ini.Load failed: %v", err)
+	}
+	uc := defaultUpstreamConfig
+	if err := readUpstreamConfig(settings.Section(""), &uc); err != nil {
+		t.Fatalf("readUpstreamConfig failed: %v", err)
+	}
+	if uc.Timeout != 7*time.Second || uc.DialTimeout != 2*time.Second || uc.KeepAlive != defaultUpstreamConfig.KeepAlive ||
+		uc.MaxIdleConnsPerHost != 4 || uc.MaxConnsPerHost != 0 || !uc.InsecureSkipVerify || uc.TLSMinVersion != tls.VersionTLS13 {
+		t.Errorf("unexpected upstream config %+v", uc)
+	}
+
+	invalid := []string{
+		"upstream-tls-min-version = 1.4",
+		"upstream-timeout = soon",
+		"upstream-idle-conn-timeout = -1s",
+		"upstream-max-idle-conns = many",
+		"upstream-max-conns-per-host = -2",
+		"upstream-insecure-skip-verify = perhaps",
+		"upstream-proxy = ftp://proxy.example.com",
+		"outbound-allowed-schemes = gopher",
+	}
+	for _, line := range invalid {
+		settings, err := ini.Load([]byte(line))
+		if err != nil {
+			t.Fatalf("ini.Load(%s) failed: %v", line, err)
+		}
+		uc := defaultUpstreamConfig
+		if err := readUpstreamConfig(settings.Section(""), &uc); err == nil {
+			t.Errorf("%s expected an error", line)
+		}
+	}
+}
+
+func TestNewUpstreamTransport(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := writeTestCertificate(t, dir, time.Now().Add(time.Hour))
+	emptyFile := filepath.Join(dir, "empty.pem")
+	_ = os.WriteFile(emptyFile, []byte("no certificate here"), 0o600)
+
+	uc := defaultUpstreamConfig
+	uc.CAFile, uc.TLSMinVersion, uc.MaxConnsPerHost = caFile, tls.VersionTLS13, 3
+	transport, err := newUpstreamTransport(&uc)
+	if err != nil {
+		t.Fatalf("newUpstreamTransport failed: %v", err)
+	}
+	if transport.TLSClientConfig.RootCAs == nil || transport.TLSClientConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLS settings were not applied: %+v", transport.TLSClientConfig)
+	}
+	if transport.MaxConnsPerHost != 3 || transport.IdleConnTimeout != uc.IdleConnTimeout || transport.ResponseHeaderTimeout != uc.ResponseHeaderTimeout {
+		t.Errorf("connection limits were not applied: %+v", transport)
+	}
+
+	for _, file := range []string{filepath.Join(dir, "missing.pem"), emptyFile} {
+		uc := defaultUpstreamConfig
+		uc.CAFile = file
+		if _, err := newUpstreamTransport(&uc); err == nil {
+			t.Errorf("CA file %s expected an error", file)
+		}
+	}
+}